@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// isAdmin returns true if a request carries Config.AdminToken, in the X-Admin-Token header or the admin_token cookie.
+func isAdmin(r *http.Request) bool {
+
+	if Config.AdminToken == "" {
+		return false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if token == "" {
+		if cookie, err := r.Cookie("admin_token"); err == nil {
+			token = cookie.Value
+		}
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(Config.AdminToken)) == 1
+}
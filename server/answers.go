@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Answer is an instant answer to a query, computed by an AnswerProvider and displayed above the hits.
+type Answer struct {
+
+	// Type is the name of the provider of the answer, like "calculator".
+	Type string `json:"t"`
+
+	// Confidence is between 0 and 1. Only the most confident answer is displayed.
+	Confidence float64 `json:"c"`
+
+	// Data is the answer itself. Its type depends on the provider.
+	Data interface{} `json:"d"`
+
+	// HTML is Data rendered with Template, so that all clients display answers the same way.
+	HTML string `json:"h"`
+
+	// Template is the name of the template in templates/answers/ used to render Data.
+	// Defaults to [Type].html
+	Template string `json:"-"`
+}
+
+// AnswerProvider computes a kind of instant answers. Providers are registered at init time
+// with RegisterAnswerProvider and enabled with Config.AnswerProviders.
+type AnswerProvider interface {
+
+	// Name identifies the provider in Config.AnswerProviders and in Answer.Type.
+	Name() string
+
+	// Match tells quickly if the provider may answer a request.
+	Match(req SearchRequest) bool
+
+	// Answer computes the answer to a matching request, or returns nil if there is none after all.
+	// It runs concurrently with the Elasticsearch requests and should give up when ctx is done.
+	Answer(ctx context.Context, req SearchRequest) (*Answer, error)
+}
+
+// registeredAnswerProvider is an AnswerProvider with its default timeout.
+type registeredAnswerProvider struct {
+	provider AnswerProvider
+	timeout  time.Duration
+}
+
+// answerProviders are all the registered providers, by name.
+var answerProviders = make(map[string]registeredAnswerProvider)
+
+// RegisterAnswerProvider makes a provider available. The timeout can be changed with Config.AnswerTimeouts.
+func RegisterAnswerProvider(provider AnswerProvider, timeout time.Duration) {
+	answerProviders[provider.Name()] = registeredAnswerProvider{provider, timeout}
+}
+
+// getAnswerTimeout returns the timeout of a provider, from Config.AnswerTimeouts if set there.
+func getAnswerTimeout(name string) time.Duration {
+
+	for _, setting := range strings.Split(Config.AnswerTimeouts, ",") {
+		parts := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(parts) == 2 && parts[0] == name {
+			if ms, err := strconv.Atoi(parts[1]); err == nil {
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	return answerProviders[name].timeout
+}
+
+// getEnabledAnswerProviders returns the registered providers listed in Config.AnswerProviders, in order.
+func getEnabledAnswerProviders() []AnswerProvider {
+
+	var providers []AnswerProvider
+
+	for _, name := range strings.Split(Config.AnswerProviders, ",") {
+		if registered, ok := answerProviders[strings.TrimSpace(name)]; ok {
+			providers = append(providers, registered.provider)
+		}
+	}
+
+	return providers
+}
+
+// StartAnswers runs the enabled providers matching a request concurrently, each one within its
+// timeout. The channel receives the most confident answer, or nil. Answers are only given on
+// the first page of results.
+func (req SearchRequest) StartAnswers() <-chan *Answer {
+
+	best := make(chan *Answer, 1)
+
+	if req.Page > 1 || req.Cursor != "" {
+		best <- nil
+		return best
+	}
+
+	var providers []AnswerProvider
+	for _, provider := range getEnabledAnswerProviders() {
+		if provider.Match(req) {
+			providers = append(providers, provider)
+		}
+	}
+
+	go func() {
+
+		answers := make([]*Answer, len(providers))
+
+		var wg sync.WaitGroup
+		for i, provider := range providers {
+			wg.Add(1)
+			go func(i int, provider AnswerProvider) {
+				defer wg.Done()
+				answers[i] = req.getAnswer(provider)
+			}(i, provider)
+		}
+		wg.Wait()
+
+		// On ties, the first provider in Config.AnswerProviders wins.
+		var answer *Answer
+		for _, a := range answers {
+			if a != nil && a.Confidence >= Config.AnswerMinConfidence && (answer == nil || a.Confidence > answer.Confidence) {
+				answer = a
+			}
+		}
+		if answer != nil && !answer.render() {
+			answer = nil
+		}
+		best <- answer
+	}()
+
+	return best
+}
+
+// getAnswer asks a single provider for an answer, and gives up after its timeout.
+func (req SearchRequest) getAnswer(provider AnswerProvider) *Answer {
+
+	ctx, cancel := context.WithTimeout(context.Background(), getAnswerTimeout(provider.Name()))
+	defer cancel()
+
+	result := make(chan *Answer, 1)
+
+	go func() {
+		answer, err := provider.Answer(ctx, req)
+		if err != nil {
+			log.Printf("Answer provider %s failed: %s", provider.Name(), err)
+			answer = nil
+		}
+		result <- answer
+	}()
+
+	select {
+	case answer := <-result:
+		if answer != nil {
+			answer.Type = provider.Name()
+		}
+		return answer
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// render fills Answer.HTML from its template. It returns false if that failed.
+func (a *Answer) render() bool {
+
+	name := a.Template
+	if name == "" {
+		name = a.Type + ".html"
+	}
+
+	var html bytes.Buffer
+	if err := Templates["answers"].ExecuteTemplate(&html, name, a.Data); err != nil {
+		log.Printf("Could not render answer %s: %s", a.Type, err)
+		return false
+	}
+
+	a.HTML = html.String()
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowProvider never answers before its context is done.
+type slowProvider struct{}
+
+func (slowProvider) Name() string {
+	return "xxxslow"
+}
+
+func (slowProvider) Match(req SearchRequest) bool {
+	return true
+}
+
+func (slowProvider) Answer(ctx context.Context, req SearchRequest) (*Answer, error) {
+	time.Sleep(time.Second)
+	return &Answer{Confidence: 1, Data: CalculationAnswer{"slow", "slow"}, Template: "calculator.html"}, nil
+}
+
+func init() {
+	RegisterAnswerProvider(slowProvider{}, 10*time.Millisecond)
+}
+
+func TestStartAnswers(t *testing.T) {
+
+	answer := <-SearchRequest{Query: "2^10 * 3", Page: 1}.StartAnswers()
+	if answer == nil || answer.Type != "calculator" || answer.Data.(CalculationAnswer).Output != "3072" {
+		t.Fatalf("Should answer calculations: %#v", answer)
+	}
+	if answer.HTML != `<span class="i">2^10 * 3 = </span><span class="o">3072</span>`+"\n" {
+		t.Fatalf("Should render answers: %q", answer.HTML)
+	}
+
+	answer = <-SearchRequest{Query: "5 miles in km", Page: 1}.StartAnswers()
+	if answer == nil || answer.Type != "conversion" || answer.Data.(CalculationAnswer).Output != "8.04672 km" {
+		t.Fatalf("Should answer conversions: %#v", answer)
+	}
+
+	answer = <-SearchRequest{Query: "What is my user agent?", Page: 1, UserAgent: "xxxbrowser"}.StartAnswers()
+	if answer == nil || answer.Type != "useragent" || answer.Data.(UserAgentAnswer).UserAgent != "xxxbrowser" {
+		t.Fatalf("Should answer user agents: %#v", answer)
+	}
+
+	if <-(SearchRequest{Query: "2^10 * 3", Page: 2}).StartAnswers() != nil {
+		t.Fatal("Should only answer on the first page")
+	}
+
+	if <-(SearchRequest{Query: "common search", Page: 1}).StartAnswers() != nil {
+		t.Fatal("Should not answer regular queries")
+	}
+}
+
+func TestAnswerProvidersConfig(t *testing.T) {
+
+	defer func(v string) { Config.AnswerProviders = v }(Config.AnswerProviders)
+	defer func(v string) { Config.AnswerTimeouts = v }(Config.AnswerTimeouts)
+
+	Config.AnswerProviders = "conversion"
+	if <-(SearchRequest{Query: "2^10 * 3", Page: 1}).StartAnswers() != nil {
+		t.Fatal("Disabled providers should not answer")
+	}
+
+	Config.AnswerProviders = "xxxslow,calculator"
+
+	start := time.Now()
+	answer := <-SearchRequest{Query: "2^10 * 3", Page: 1}.StartAnswers()
+	if answer == nil || answer.Type != "calculator" {
+		t.Fatalf("Slow providers should be ignored: %#v", answer)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("Slow providers should time out")
+	}
+
+	Config.AnswerTimeouts = "xxxslow=2000"
+	if getAnswerTimeout("xxxslow") != 2*time.Second || getAnswerTimeout("calculator") != 50*time.Millisecond {
+		t.Fatal("Timeouts should be configurable")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/commonsearch/cosr-front/server/query"
+	"net/http"
+	"strings"
+)
+
+// autocompleteFields are the fields of the text index matched by the word being typed.
+var autocompleteFields = []string{"title", "domain_words"}
+
+// AutocompleteResult is the JSON return of /api/suggest.
+type AutocompleteResult struct {
+	Query       string   `json:"q"`
+	Completions []string `json:"s"`
+}
+
+// splitLastWord splits a query in the words already typed and the one being typed.
+func splitLastWord(q string) (string, string) {
+	i := strings.LastIndexAny(q, " \t")
+	return q[:i+1], q[i+1:]
+}
+
+// luceneRegexpEscaper escapes the reserved characters of Lucene regular expressions.
+var luceneRegexpEscaper = strings.NewReplacer(
+	`\`, `\\`, `.`, `\.`, `?`, `\?`, `+`, `\+`, `*`, `\*`, `|`, `\|`, `{`, `\{`, `}`, `\}`,
+	`[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`, `"`, `\"`, `#`, `\#`, `@`, `\@`, `&`, `\&`,
+	`<`, `\<`, `>`, `\>`, `~`, `\~`)
+
+// BuildAutocompleteRequest returns a JSON-encoded Elasticsearch query body for the text index,
+// aggregating the words of Config.AutocompleteField that start with the word being typed, in the
+// documents allowed by the safe search level.
+func BuildAutocompleteRequest(head string, prefix string, lang string, safe string) (string, error) {
+
+	must := []esObject{{"multi_match": esObject{
+		"query":  prefix,
+		"type":   "phrase_prefix",
+		"fields": autocompleteFields,
+	}}}
+
+	// The completions must make sense with the words already typed
+	if parsedHead := query.Parse(head); parsedHead != nil {
+		must = append(must, BuildTextQuery(parsedHead, GetRankingProfile("", lang)))
+	}
+
+	boolQuery := esObject{"must": must}
+	var filters []esObject
+	if langFilter := buildLangFilter(lang); langFilter != nil {
+		filters = append(filters, langFilter)
+	}
+	if safeFilter := buildSafeSearchExclusion(safe); safeFilter != nil {
+		filters = append(filters, safeFilter)
+	}
+	if len(filters) == 1 {
+		boolQuery["filter"] = filters[0]
+	} else if len(filters) > 1 {
+		boolQuery["filter"] = filters
+	}
+
+	aggs := esObject{"completions": esObject{"terms": esObject{
+		"field":   Config.AutocompleteField,
+		"include": luceneRegexpEscaper.Replace(strings.ToLower(prefix)) + ".*",
+		"size":    Config.AutocompleteSize,
+	}}}
+
+	jsonQuery, err := json.Marshal(esObject{"bool": boolQuery})
+	if err != nil {
+		return "", err
+	}
+
+	jsonAggs, err := json.Marshal(aggs)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`{
+      "size": 0,
+      "timeout": "%dms",
+      "terminate_after": 10000,
+      "query": %s,
+      "aggs": %s
+    }`, Config.AutocompleteTimeoutMs, jsonQuery, jsonAggs), nil
+}
+
+// Autocomplete returns completions for a partially typed query.
+func Autocomplete(q string, lang string, safe string) ([]string, error) {
+
+	head, prefix := splitLastWord(q)
+
+	// Bangs are completed from their definitions
+	if strings.HasPrefix(prefix, "!") {
+		var completions []string
+		for _, name := range CompleteBang(prefix[1:], Config.AutocompleteSize) {
+			completions = append(completions, head+"!"+name)
+		}
+		return completions, nil
+	}
+
+	// Queries searched by other people come first, even after a space. They aren't filtered,
+	// so strict safe search only completes from the documents.
+	var popular []string
+	if safe != SafeSearchStrict {
+		popular = CompletePopularQuery(q, lang, Config.AutocompleteSize)
+	}
+
+	if prefix == "" || Config.TestData || Config.AutocompleteField == "" {
+		return popular, nil
+	}
+
+	esBody, err := BuildAutocompleteRequest(head, prefix, lang, safe)
+	if err != nil {
+		return nil, err
+	}
+
+	result, _, err := ElasticsearchRequest(
+		ElasticsearchAutocompleteClient,
+		"/text/page/_search",
+		esBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets are sorted by decreasing document counts
+	var words []string
+	if terms, found := result.Aggregations.Terms("completions"); found {
+		for _, bucket := range terms.Buckets {
+			if word, isString := bucket.Key.(string); isString {
+				words = append(words, word)
+			}
+		}
+	}
+
+	return mergeCompletions(popular, head, words), nil
+}
+
+// mergeCompletions appends the completions of the last word to the popular ones, without duplicates.
+func mergeCompletions(popular []string, head string, words []string) []string {
+
+	completions := popular
+	seen := make(map[string]bool)
+	for _, completion := range popular {
+		seen[completion] = true
+	}
+
+	for _, word := range words {
+		if len(completions) >= Config.AutocompleteSize {
+			break
+		}
+		if completion := head + word; !seen[completion] {
+			completions = append(completions, completion)
+			seen[completion] = true
+		}
+	}
+
+	return completions
+}
+
+// MarshalOpenSearch returns the completions in the OpenSearch suggestions format:
+// ["query", ["completion 1", "completion 2"]]
+// See http://www.opensearch.org/Specifications/OpenSearch/Extensions/Suggestions/1.1
+func (res AutocompleteResult) MarshalOpenSearch() ([]byte, error) {
+	return json.Marshal([]interface{}{res.Query, res.Completions})
+}
+
+// APISuggestHandler handles HTTP queries to our autocomplete API (/api/suggest?q=*)
+// With format=opensearch, completions are returned in the format expected by browsers.
+func APISuggestHandler(w http.ResponseWriter, r *http.Request) {
+
+	openSearch := (r.FormValue("format") == "opensearch")
+
+	if openSearch {
+		w.Header().Set("Content-Type", "application/x-suggestions+json")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	q := strings.TrimLeft(r.FormValue("q"), " ")
+
+	lang := r.FormValue("g")
+	if !validLangRegexp.MatchString(lang) {
+		lang = "en"
+	}
+
+	completions, err := Autocomplete(q, lang, getSafeSearch(r))
+	if err != nil {
+		// Autocomplete is best-effort: timeouts shouldn't be cached nor displayed.
+		w.Header().Set("Cache-Control", "no-cache")
+		completions = nil
+	} else {
+		// Completions include popular queries and depend on the safe search cookie: they can't be
+		// shared, and shouldn't outlive the popular queries for long.
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	}
+
+	if completions == nil {
+		completions = []string{}
+	}
+
+	result := AutocompleteResult{Query: q, Completions: completions}
+
+	if openSearch {
+		var body []byte
+		body, err = result.MarshalOpenSearch()
+		if err == nil {
+			_, err = w.Write(body)
+		}
+	} else {
+		err = json.NewEncoder(w).Encode(result)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
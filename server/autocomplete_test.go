@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAutocompleteBangs(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/api/suggest?g=en&q=foo+bar+!pyt")
+
+	if body != `{"q":"foo bar !pyt","s":["foo bar !python"]}`+"\n" {
+		t.Fatalf("Wrong bang completions: %s", body)
+	}
+
+	if search(t, "/api/suggest?q=foo+") != `{"q":"foo ","s":[]}`+"\n" {
+		t.Fatal("No completions after a space")
+	}
+}
+
+func TestAutocompleteHandler(t *testing.T) {
+	t.Parallel()
+
+	resp, err := http.Get(server.URL + "/api/suggest?g=%22x&q=!pyt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Invalid languages should fall back to English, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Cache-Control") != "private, max-age=300" {
+		t.Fatalf("Completions shouldn't be cached publicly: %s", resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestBuildAutocompleteRequest(t *testing.T) {
+
+	defer func(v string) { Config.AutocompleteField = v }(Config.AutocompleteField)
+	Config.AutocompleteField = "words"
+
+	body, err := BuildAutocompleteRequest("foo -bar ", "Ba.z", "fr", SafeSearchOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`"terms":{"field":"words","include":"ba\\.z.*","size":8}`,
+		`"filter":{"exists":{"field":"lang_fr"}}`,
+		`"must_not":`,
+		`"type":"phrase_prefix"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %s in %s", expected, body)
+		}
+	}
+
+	body, err = BuildAutocompleteRequest("", "baz", "fr", SafeSearchModerate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"filter":[{"exists":{"field":"lang_fr"}},{"bool":{"must_not":{"range":{"adult":{"gte":0.6}}}}}]`) {
+		t.Fatalf("Completions should exclude adult content: %s", body)
+	}
+}
+
+func TestAutocompleteOpenSearch(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/api/suggest?g=en&q=!pyt&format=opensearch")
+
+	if body != `["!pyt",["!python"]]` {
+		t.Fatalf("Wrong OpenSearch completions: %s", body)
+	}
+}
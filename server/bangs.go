@@ -5,11 +5,15 @@ import (
 	"io/ioutil"
 	"log"
 	"path"
+	"sort"
 	"strings"
 )
 
 var bangs = make(map[string](map[string]string))
 
+// bangNames is the sorted list of all the bangs we support.
+var bangNames []string
+
 // LoadBangs loads bang definitions from a static JSON file at startup.
 func LoadBangs() {
 
@@ -21,6 +25,27 @@ func LoadBangs() {
 	if err := json.Unmarshal(cnt, &bangs); err != nil {
 		log.Fatal(err)
 	}
+
+	bangNames = make([]string, 0, len(bangs))
+	for name := range bangs {
+		bangNames = append(bangNames, name)
+	}
+	sort.Strings(bangNames)
+}
+
+// CompleteBang returns the names of the bangs starting with a prefix, in alphabetical order.
+func CompleteBang(prefix string, max int) []string {
+
+	var names []string
+
+	for _, name := range bangNames[sort.SearchStrings(bangNames, prefix):] {
+		if !strings.HasPrefix(name, prefix) || len(names) >= max {
+			break
+		}
+		names = append(names, name)
+	}
+
+	return names
 }
 
 // DetectBang detects bang usage in a query string and returns a redirect URL if found.
@@ -34,3 +34,19 @@ func TestBangs(t *testing.T) {
 		t.Fatal("No Amazon bang")
 	}
 }
+
+func TestCompleteBang(t *testing.T) {
+	t.Parallel()
+
+	if strings.Join(CompleteBang("py", 10), ",") != "py,pypi,python" {
+		t.Fatal("Wrong bang completions")
+	}
+
+	if strings.Join(CompleteBang("py", 2), ",") != "py,pypi" {
+		t.Fatal("Bang completions should be limited")
+	}
+
+	if len(CompleteBang("zzz", 10)) != 0 {
+		t.Fatal("Unknown bang")
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Bounds on the expressions we evaluate, so that pathological queries can't hurt us.
+const (
+	calcMaxLength = 100
+	calcMaxTokens = 64
+	calcMaxDepth  = 16
+)
+
+var errNotAnExpression = errors.New("not an arithmetic expression")
+
+// calcNumberListRegexp matches numbers joined by "-" or "/" without spaces, which are much more
+// often phone numbers, dates or ranges like "1-800-555-1234", "2016-05-12" or "1/2/2016".
+var calcNumberListRegexp = regexp.MustCompile(`^[+-]?[0-9]+([-/][0-9]+)+$`)
+
+// CalculationAnswer is the Data of calculator and conversion answers.
+type CalculationAnswer struct {
+	Input  string `json:"i"`
+	Output string `json:"o"`
+}
+
+// calculatorProvider answers arithmetic expressions like "2^10 * 3".
+type calculatorProvider struct{}
+
+func init() {
+	RegisterAnswerProvider(calculatorProvider{}, 50*time.Millisecond)
+}
+
+func (calculatorProvider) Name() string {
+	return "calculator"
+}
+
+func (calculatorProvider) Match(req SearchRequest) bool {
+	return strings.ContainsAny(req.Query, "+-*/^x×÷(")
+}
+
+func (calculatorProvider) Answer(ctx context.Context, req SearchRequest) (*Answer, error) {
+
+	value, err := Calculate(req.Query)
+	if err != nil {
+		return nil, nil
+	}
+
+	return &Answer{
+		Confidence: 1,
+		Data: CalculationAnswer{
+			Input:  strings.TrimSpace(strings.TrimSuffix(req.Query, "=")),
+			Output: formatCalcNumber(value),
+		},
+	}, nil
+}
+
+// calcFunctions are the functions allowed in expressions, like sqrt(2).
+var calcFunctions = map[string]func(float64) float64{
+	"sqrt": math.Sqrt,
+	"abs":  math.Abs,
+	"ln":   math.Log,
+	"log":  math.Log10,
+	"exp":  math.Exp,
+	"sin":  math.Sin,
+	"cos":  math.Cos,
+	"tan":  math.Tan,
+}
+
+// calcConstants are the named numbers allowed in expressions.
+var calcConstants = map[string]float64{
+	"pi": math.Pi,
+	"π":  math.Pi,
+	"e":  math.E,
+}
+
+type calcToken struct {
+	op    rune // one of +-*/^() or 0 for numbers and names
+	num   float64
+	name  string
+	isNum bool
+}
+
+// calcTokenize splits an expression in tokens. "x" and "×" are multiplications, "÷" a division.
+func calcTokenize(expr string) ([]calcToken, error) {
+
+	var tokens []calcToken
+	runes := []rune(strings.ToLower(expr))
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case strings.ContainsRune("+-*/^()", r):
+			tokens = append(tokens, calcToken{op: r})
+			i++
+
+		case r == '×':
+			tokens = append(tokens, calcToken{op: '*'})
+			i++
+
+		case r == '÷':
+			tokens = append(tokens, calcToken{op: '/'})
+			i++
+
+		case unicode.IsDigit(r) || r == '.':
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			num, err := strconv.ParseFloat(string(runes[i:end]), 64)
+			if err != nil {
+				return nil, errNotAnExpression
+			}
+			tokens = append(tokens, calcToken{num: num, isNum: true})
+			i = end
+
+		case unicode.IsLetter(r):
+			end := i
+			for end < len(runes) && unicode.IsLetter(runes[end]) {
+				end++
+			}
+			name := string(runes[i:end])
+			i = end
+
+			if name == "x" {
+				tokens = append(tokens, calcToken{op: '*'})
+				break
+			}
+			if _, ok := calcFunctions[name]; !ok {
+				if _, ok := calcConstants[name]; !ok {
+					return nil, errNotAnExpression
+				}
+			}
+			tokens = append(tokens, calcToken{name: name})
+
+		default:
+			return nil, errNotAnExpression
+		}
+
+		if len(tokens) > calcMaxTokens {
+			return nil, errNotAnExpression
+		}
+	}
+
+	return tokens, nil
+}
+
+// calcParser evaluates expressions with the usual precedence rules:
+//
+//	sum     := product (("+" | "-") product)*
+//	product := unary (("*" | "/") unary)*
+//	unary   := ("-" | "+") unary | power
+//	power   := primary ("^" unary)?
+//	primary := NUMBER | CONSTANT | FUNCTION "(" sum ")" | "(" sum ")"
+type calcParser struct {
+	tokens []calcToken
+	pos    int
+	depth  int
+}
+
+func (p *calcParser) peek() *calcToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *calcParser) isOp(op rune) bool {
+	tok := p.peek()
+	return tok != nil && tok.op == op
+}
+
+func (p *calcParser) sum() (float64, error) {
+	value, err := p.product()
+	for err == nil && (p.isOp('+') || p.isOp('-')) {
+		op := p.peek().op
+		p.pos++
+		var right float64
+		right, err = p.product()
+		if op == '+' {
+			value += right
+		} else {
+			value -= right
+		}
+	}
+	return value, err
+}
+
+func (p *calcParser) product() (float64, error) {
+	value, err := p.unary()
+	for err == nil && (p.isOp('*') || p.isOp('/')) {
+		op := p.peek().op
+		p.pos++
+		var right float64
+		right, err = p.unary()
+		if op == '*' {
+			value *= right
+		} else {
+			value /= right
+		}
+	}
+	return value, err
+}
+
+func (p *calcParser) unary() (float64, error) {
+	if p.isOp('-') || p.isOp('+') {
+		op := p.peek().op
+		p.pos++
+		value, err := p.unary()
+		if op == '-' {
+			value = -value
+		}
+		return value, err
+	}
+	return p.power()
+}
+
+func (p *calcParser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil || !p.isOp('^') {
+		return base, err
+	}
+	p.pos++
+
+	// Exponentiation is right-associative: 2^3^2 = 2^9
+	exponent, err := p.unary()
+	return math.Pow(base, exponent), err
+}
+
+func (p *calcParser) primary() (float64, error) {
+
+	tok := p.peek()
+	if tok == nil {
+		return 0, errNotAnExpression
+	}
+	p.pos++
+
+	if tok.isNum {
+		return tok.num, nil
+	}
+
+	if value, ok := calcConstants[tok.name]; ok {
+		return value, nil
+	}
+
+	fn := calcFunctions[tok.name]
+	if fn != nil {
+		if !p.isOp('(') {
+			return 0, errNotAnExpression
+		}
+		p.pos++
+	} else if tok.op != '(' {
+		return 0, errNotAnExpression
+	}
+
+	p.depth++
+	if p.depth > calcMaxDepth {
+		return 0, errNotAnExpression
+	}
+
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+
+	// Unbalanced parentheses are closed at the end of the expression.
+	if p.isOp(')') {
+		p.pos++
+	} else if p.peek() != nil {
+		return 0, errNotAnExpression
+	}
+	p.depth--
+
+	if fn != nil {
+		value = fn(value)
+	}
+	return value, nil
+}
+
+// Calculate evaluates an arithmetic expression like "2^10 * 3". Expressions must contain at least
+// one operator or function: plain numbers are not calculations. Numbers joined by "-" or "/" only
+// are calculations when asked explicitly, like "10 / 4" or "10/4 =".
+func Calculate(expr string) (float64, error) {
+
+	expr = strings.TrimSpace(expr)
+	if strings.HasSuffix(expr, "=") {
+		expr = strings.TrimSuffix(expr, "=")
+	} else if calcNumberListRegexp.MatchString(expr) {
+		return 0, errNotAnExpression
+	}
+
+	if utf8.RuneCountInString(expr) > calcMaxLength {
+		return 0, errNotAnExpression
+	}
+
+	tokens, err := calcTokenize(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	isCalculation := false
+	for i, tok := range tokens {
+		// A leading minus is just a negative number.
+		if (tok.op != 0 && tok.op != '(' && tok.op != ')' && !(i == 0 && tok.op == '-')) || calcFunctions[tok.name] != nil {
+			isCalculation = true
+		}
+	}
+	if !isCalculation {
+		return 0, errNotAnExpression
+	}
+
+	return calcEvaluate(tokens)
+}
+
+// calcEvaluate evaluates a list of tokens, which may be a plain number.
+func calcEvaluate(tokens []calcToken) (float64, error) {
+
+	p := calcParser{tokens: tokens}
+
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != nil {
+		return 0, errNotAnExpression
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, errNotAnExpression
+	}
+
+	return value, nil
+}
+
+// formatCalcNumber formats the result of a calculation with 12 significant digits,
+// so that 0.1 + 0.2 is 0.3 and not 0.30000000000000004
+func formatCalcNumber(value float64) string {
+
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 12, 64), 64)
+
+	if rounded == math.Trunc(rounded) && math.Abs(rounded) < 1e15 {
+		return strconv.FormatFloat(rounded, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(rounded, 'g', -1, 64)
+}
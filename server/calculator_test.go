@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"2^10 * 3":        "3072",
+		"1 + 2 * 3":       "7",
+		"(1 + 2) * 3":     "9",
+		"2^3^2":           "512",
+		"-2^2":            "-4",
+		"0.1 + 0.2":       "0.3",
+		"10 / 4 =":        "2.5",
+		"3 x 4 × 2 ÷ 8":   "3",
+		"sqrt(16) + pi":   "7.14159265359",
+		"2 * (3 + 4":      "14",
+		"1e3 + 1":         "",
+		"2^100000":        "",
+		"1 / 0":           "",
+		"42":              "",
+		"-42":             "",
+		"(42)":            "",
+		"2016-2017":       "",
+		"2016 - 2017":     "-1",
+		"2016-2017 =":     "-1",
+		"2016-05-12":      "",
+		"1-800-555-1234":  "",
+		"+1-800-555-1234": "",
+		"1/2/2016":        "",
+		"10/4":            "",
+		"10/4=":           "2.5",
+		"(555) 555-1234":  "",
+		"555-1234":        "",
+		"2*3-1":           "5",
+		"sqrt 2":          "",
+		"hello + world":   "",
+		"3 ++ 4":          "7",
+		"1 2":             "",
+		"2 * ((((3)))))":  "",
+	}
+
+	for expr, want := range tests {
+		got := ""
+		if value, err := Calculate(expr); err == nil {
+			got = formatCalcNumber(value)
+		}
+		if got != want {
+			t.Errorf("Calculate(%q) = %q, want %q", expr, got, want)
+		}
+	}
+}
+
+func TestCalculateIsBounded(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Calculate(strings.Repeat("(", 20) + "1+1"); err == nil {
+		t.Fatal("Should refuse deep expressions")
+	}
+	if _, err := Calculate(strings.Repeat("1+", 40) + "1"); err == nil {
+		t.Fatal("Should refuse long expressions")
+	}
+	if _, err := Calculate(strings.Repeat("-", 90) + "1+1"); err == nil {
+		t.Fatal("Should refuse expressions with too many tokens")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"5 miles in km":        "5 mi = 8.04672 km",
+		"5km to miles":         "5 km = 3.10685596119 mi",
+		"100 °F in celsius":    "100 °F = 37.7777777778 °C",
+		"0 c to k":             "0 °C = 273.15 K",
+		"2*3 kg in lbs":        "6 kg = 13.2277357311 lb",
+		"1 GiB in MB":          "1 GiB = 1073.741824 MB",
+		"90 min in hours":      "90 min = 1.5 h",
+		"12 in in cm":          "12 in = 30.48 cm",
+		"5 km in kg":           "",
+		"km in miles":          "",
+		"learn c in 21 days":   "",
+		"5 miles in furlongs":  "",
+		"10 square feet to m2": "10 ft² = 0.9290304 m²",
+	}
+
+	for q, want := range tests {
+		got := ""
+		if input, output, ok := Convert(q); ok {
+			got = input + " = " + output
+		}
+		if got != want {
+			t.Errorf("Convert(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
@@ -31,6 +31,13 @@ type ConfigSpec struct {
 	// Host sets the IP address we are listening on for requests. Set to 127.0.0.1 to restrict to local.
 	Host string `default:"0.0.0.0"`
 
+	// PublicHost is the host name used in absolute URLs we generate, like in /opensearch.xml.
+	// Defaults to the Host header of the request.
+	PublicHost string `default:""`
+
+	// PublicScheme is the scheme used in absolute URLs we generate. Defaults to the scheme of the request.
+	PublicScheme string `default:""`
+
 	// ElasticsearchDocs is the HTTP url of the Elasticsearch instance for the document store.
 	ElasticsearchDocs string `default:"http://__local_docker_host__:39200"`
 
@@ -43,8 +50,194 @@ type ConfigSpec struct {
 	// ResultPageSize controls the number of results on each page.
 	ResultPageSize int `default:"25"`
 
+	// MaxPage is the last page available with offset paging (p=). Clients can go further with cursors.
+	MaxPage int `default:"40"`
+
+	// CursorKeepAlive is the time in seconds a cursor (cursor=) stays valid between two requests.
+	CursorKeepAlive int `default:"60"`
+
+	// MaxHitsPerDomain limits the number of hits from the same domain on each result page. 0 disables it.
+	MaxHitsPerDomain int `default:"0"`
+
+	// DomainFacets is the number of top domains returned with each search, to filter by site. 0 disables them.
+	DomainFacets int `default:"0"`
+
+	// DomainFacetField is the field of the text index aggregated for domain facets.
+	DomainFacetField string `default:"domain"`
+
+	// RelatedSearches is the number of related searches under the hits, built from the significant terms
+	// of the top hits and from popular queries. 0 disables them.
+	RelatedSearches int `default:"0"`
+
+	// RelatedField is the field of the text index where significant terms are found. It must be a
+	// not_analyzed field with doc values, like a keyword sub-field of the body: significant terms on an
+	// analyzed field load its fielddata in the heap of Elasticsearch. Empty only uses popular queries.
+	RelatedField string `default:""`
+
+	// RelatedSampleSize is the number of top hits of each shard sampled for significant terms.
+	RelatedSampleSize int `default:"100"`
+
+	// SnippetMode is the default way hit summaries are built: "summary" uses the stored summary of
+	// each document, "highlight" uses the fragments matching the query. Can be changed with sn=.
+	SnippetMode string `default:"summary"`
+
+	// SnippetIndex is the index asked for highlighted fragments: "text" or "docs".
+	SnippetIndex string `default:"text"`
+
+	// SnippetField is the field highlighted fragments are extracted from.
+	SnippetField string `default:"body"`
+
+	// SnippetFragmentSize is the approximate length in characters of each highlighted fragment.
+	SnippetFragmentSize int `default:"150"`
+
+	// SafeSearch is the default safe search level: "strict", "moderate" or "off".
+	// Users can change it with the "safe" URL parameter, which is then remembered in a cookie.
+	SafeSearch string `default:"moderate"`
+
+	// SafeSearchField is the field of the text index with the probability of a document being adult content.
+	SafeSearchField string `default:"adult"`
+
+	// SafeSearchStrictThreshold is the SafeSearchField value above which documents are filtered in "strict".
+	SafeSearchStrictThreshold float64 `default:"0.2"`
+
+	// SafeSearchModerateThreshold is the SafeSearchField value above which documents are demoted in "moderate".
+	SafeSearchModerateThreshold float64 `default:"0.6"`
+
+	// SafeSearchModerateWeight multiplies the score of demoted documents.
+	SafeSearchModerateWeight float64 `default:"0.1"`
+
+	// LuckyPrefix at the start of a query redirects to the first hit, like "\common search". Empty disables it.
+	LuckyPrefix string `default:"\\"`
+
+	// NavigationalMode controls queries that name a page of the docs index, like "example.com":
+	// "redirect" goes straight to the page, "pin" displays it before the hits, "off" does nothing special.
+	NavigationalMode string `default:"pin"`
+
+	// DocsURLField is the field of the docs index with the exact URL of pages.
+	DocsURLField string `default:"url"`
+
+	// RankingProfiles is the JSON file defining the ranking profiles, relative to PathFront. See RankingProfile.
+	RankingProfiles string `default:"server/profiles.json"`
+
+	// Experiments are the interleaving experiments between ranking profiles, comma-separated like
+	// "name:profileA:profileB:share", where share is the percentage of sessions in the experiment.
+	Experiments string `default:""`
+
+	// ExperimentSecret signs the click beacons of experiments. Defaults to a random secret at startup.
+	ExperimentSecret string `default:""`
+
+	// ShadowSampleRate is the share of searches replayed on a candidate backend, between 0 and 1, to
+	// compare its results with the main one. 0 disables shadow searches.
+	ShadowSampleRate float64 `default:"0"`
+
+	// ShadowElasticsearchText and ShadowElasticsearchDocs are the HTTP urls of the candidate
+	// Elasticsearch instances. Empty means the same as ElasticsearchText and ElasticsearchDocs.
+	ShadowElasticsearchText string `default:""`
+	ShadowElasticsearchDocs string `default:""`
+
+	// ShadowTextIndex and ShadowDocsIndex are the names of the candidate indexes.
+	ShadowTextIndex string `default:"text"`
+	ShadowDocsIndex string `default:"docs"`
+
+	// ShadowConcurrency is the maximum number of shadow searches running at once. Others are skipped.
+	ShadowConcurrency int `default:"4"`
+
+	// ShadowTimeoutMs is the maximum time in milliseconds of each request of a shadow search, so that
+	// a slow candidate backend doesn't hold the shadow search slots.
+	ShadowTimeoutMs int `default:"2000"`
+
+	// QueryLogPath is a file where searches are logged as JSON lines, see QueryLogRecord. Records have
+	// no IP, cookie or user agent. Empty disables the query log, which is the default.
+	QueryLogPath string `default:""`
+
+	// QueryLogMaxSize is the size in megabytes after which the query log is rotated. 0 disables it.
+	QueryLogMaxSize int `default:"100"`
+
+	// QueryLogMaxAge is the age in hours after which the query log is rotated. 0 disables it.
+	QueryLogMaxAge int `default:"24"`
+
+	// ReportWindow is the number of minutes of searches in the zero-result and slow query reports
+	// (/admin/reports). They keep the text of queries in memory, so 0, the default, disables them.
+	ReportWindow int `default:"0"`
+
+	// SlowQueryMs is the total time in milliseconds above which a search is reported as slow.
+	SlowQueryMs int `default:"1000"`
+
+	// ReportSize is the maximum number of queries in each report.
+	ReportSize int `default:"100"`
+
+	// PopularQueries collects the queries searched by many people, to complete queries and display
+	// trending ones on the home page. Disabled by default.
+	PopularQueries bool `default:"false"`
+
+	// PopularMinSessions is the number of different networks, a /24 for IPv4 and a /64 for IPv6, that
+	// must search a query before it is suggested to others.
+	PopularMinSessions int `default:"5"`
+
+	// PopularHalfLife is the time in hours after which a search counts half as much in popular queries.
+	PopularHalfLife int `default:"168"`
+
+	// TrendingHalfLife is the half-life in hours of the recent searches compared with PopularHalfLife
+	// to find trending queries.
+	TrendingHalfLife int `default:"6"`
+
+	// PopularMaxQueries is the maximum number of public queries in each language, and of pending ones.
+	PopularMaxQueries int `default:"10000"`
+
+	// PopularQueriesPath is a JSON file where popular queries are saved, relative to PathFront, so that
+	// they survive restarts. Empty keeps them in memory only.
+	PopularQueriesPath string `default:""`
+
+	// TrustedProxies are the comma-separated IP addresses or CIDR ranges of the reverse proxies in front of
+	// the server. Their X-Forwarded-For header is used as the address of clients.
+	TrustedProxies string `default:""`
+
+	// TrendingSize is the number of trending queries on the home page. 0 disables them.
+	TrendingSize int `default:"5"`
+
 	// Maximum allowed number of words for a query
 	MaxQueryTerms int `default:"10"`
+
+	// SpellcheckMaxHits triggers a "Did you mean" suggestion for queries with fewer hits. 0 disables it.
+	SpellcheckMaxHits int64 `default:"3"`
+
+	// SpellcheckAutoCorrect runs the suggested query right away when the original one had no hits.
+	SpellcheckAutoCorrect bool `default:"true"`
+
+	// SpellcheckField is the field of the text index used to find spelling corrections.
+	SpellcheckField string `default:"body"`
+
+	// AutocompleteTimeoutMs is the maximum time in milliseconds spent by /api/suggest waiting for Elasticsearch.
+	AutocompleteTimeoutMs int `default:"150"`
+
+	// AutocompleteSize is the maximum number of completions returned by /api/suggest.
+	AutocompleteSize int `default:"8"`
+
+	// AutocompleteField is the field of the text index aggregated for the completions of the word being
+	// typed. Like RelatedField, it must be a not_analyzed field with doc values, holding lowercase words:
+	// terms aggregations on an analyzed field load its fielddata in the heap of Elasticsearch on every
+	// keystroke. Empty only completes from popular queries.
+	AutocompleteField string `default:""`
+
+	// AnswerProviders is the comma-separated list of enabled instant answer providers.
+	AnswerProviders string `default:"calculator,conversion,useragent,define"`
+
+	// AnswerTimeouts overrides the default timeouts of answer providers, like "calculator=20,useragent=10" in ms.
+	AnswerTimeouts string `default:""`
+
+	// AnswerMinConfidence is the confidence below which answers are not displayed.
+	AnswerMinConfidence float64 `default:"0.5"`
+
+	// DictionaryPath is a dictionary file for "define:" answers, in JSON-lines format, optionally gzipped.
+	// See DictionaryEntry. Empty disables definitions.
+	DictionaryPath string `default:""`
+
+	// AdminToken grants access to admin features when sent in the X-Admin-Token header or the
+	// admin_token cookie. Empty disables them.
+	AdminToken string `default:""`
+
+	// ExplainEnabled allows anyone to ask for ranking explanations with explain=1. Admins always can.
+	ExplainEnabled bool `default:"false"`
 }
 
 // Config contains the current configuration values.
@@ -59,12 +252,19 @@ func LoadConfig() {
 		log.Fatal(err.Error())
 	}
 
+	if !IsValidSafeSearch(Config.SafeSearch) {
+		log.Fatalf("Invalid safe search level %q: must be %q, %q or %q",
+			Config.SafeSearch, SafeSearchStrict, SafeSearchModerate, SafeSearchOff)
+	}
+
 	// Discover the IP of the local Docker host and replace it in the config values that may use it.
 	localDockerHost := GetDockerHostIP()
 	log.Println("Using Docker host IP: " + localDockerHost)
 
 	Config.ElasticsearchDocs = strings.Replace(Config.ElasticsearchDocs, "__local_docker_host__", localDockerHost, 1)
 	Config.ElasticsearchText = strings.Replace(Config.ElasticsearchText, "__local_docker_host__", localDockerHost, 1)
+	Config.ShadowElasticsearchDocs = strings.Replace(Config.ShadowElasticsearchDocs, "__local_docker_host__", localDockerHost, 1)
+	Config.ShadowElasticsearchText = strings.Replace(Config.ShadowElasticsearchText, "__local_docker_host__", localDockerHost, 1)
 
 }
 
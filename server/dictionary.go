@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DictionaryEntry is a line of the dictionary file, in JSON-lines format:
+//
+//	{"lang": "en", "word": "house", "senses": [{"pos": "noun", "definition": "...", "examples": ["..."]}]}
+type DictionaryEntry struct {
+	Lang   string            `json:"lang"`
+	Word   string            `json:"word"`
+	Senses []DictionarySense `json:"senses"`
+}
+
+// DictionarySense is one of the meanings of a word.
+type DictionarySense struct {
+	PartOfSpeech string   `json:"pos"`
+	Definition   string   `json:"definition"`
+	Examples     []string `json:"examples,omitempty"`
+}
+
+// dictionaryKey locates an entry in dictionary.file.
+type dictionaryKey struct {
+	key        string
+	start, end int64
+}
+
+// dictionary keeps only the keys of the entries in memory, with their offsets in the dictionary
+// file. Entries are read and decoded when they are looked up. Keys are sorted for binary search.
+var dictionary struct {
+	file *os.File
+	keys []dictionaryKey
+}
+
+const (
+	// maxDictionarySize is the largest uncompressed dictionary we load, so that a wrong path fails
+	// at startup instead of filling the temporary directory.
+	maxDictionarySize = 64 << 30
+
+	// maxDictionaryLineLength is the longest entry we read.
+	maxDictionaryLineLength = 1 << 20
+)
+
+// maxDictionarySenses is the number of senses displayed for a word.
+const maxDictionarySenses = 5
+
+// LoadDictionary loads Config.DictionaryPath at startup. The file may be gzipped.
+func LoadDictionary() {
+
+	if Config.DictionaryPath == "" {
+		return
+	}
+
+	file, err := openDictionaryFile(Config.DictionaryPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	skipped, err := loadDictionary(file)
+	if err != nil {
+		log.Fatalf("%s: %s", Config.DictionaryPath, err)
+	}
+
+	log.Printf("Loaded %d dictionary entries (%d invalid ones skipped)", len(dictionary.keys), skipped)
+}
+
+// openDictionaryFile opens a dictionary file for random access. Gzipped files are decompressed
+// to a temporary file first, which is deleted right away and freed when it is closed.
+func openDictionaryFile(filename string) (*os.File, error) {
+
+	file, err := os.Open(filename)
+	if err != nil || !strings.HasSuffix(filename, ".gz") {
+		return file, err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	uncompressed, err := ioutil.TempFile("", "cosr-dictionary")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(uncompressed.Name()); err != nil {
+		uncompressed.Close()
+		return nil, err
+	}
+
+	size, err := io.Copy(uncompressed, io.LimitReader(gzipReader, maxDictionarySize+1))
+	if err == nil && size > maxDictionarySize {
+		err = fmt.Errorf("dictionary is larger than %d bytes", int64(maxDictionarySize))
+	}
+	if err != nil {
+		uncompressed.Close()
+		return nil, err
+	}
+
+	return uncompressed, nil
+}
+
+// loadDictionary indexes the JSON lines of a dictionary file, which is kept open for lookups.
+// It returns the number of invalid lines.
+func loadDictionary(file *os.File) (int, error) {
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > maxDictionarySize {
+		return 0, fmt.Errorf("dictionary is larger than %d bytes", int64(maxDictionarySize))
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReaderSize(file, maxDictionaryLineLength)
+
+	var keys []dictionaryKey
+	skipped := 0
+
+	for offset := int64(0); ; {
+
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return 0, fmt.Errorf("entry at offset %d is longer than %d bytes", offset, maxDictionaryLineLength)
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		start := offset
+		offset += int64(len(line))
+
+		if len(bytes.TrimSpace(line)) > 0 {
+
+			// Only the key is needed for now, the rest is decoded at lookup time.
+			var entry struct {
+				Lang string `json:"lang"`
+				Word string `json:"word"`
+			}
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil || entry.Word == "" || entry.Lang == "" {
+				skipped++
+			} else {
+				keys = append(keys, dictionaryKey{
+					key:   getDictionaryKey(entry.Lang, entry.Word),
+					start: start,
+					end:   offset,
+				})
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].key < keys[j].key
+	})
+
+	if dictionary.file != nil {
+		dictionary.file.Close()
+	}
+	dictionary.file = file
+	dictionary.keys = keys
+
+	return skipped, nil
+}
+
+// getDictionaryKey returns the index key of a word, insensitive to case and diacritics.
+func getDictionaryKey(lang string, word string) string {
+	return lang + ":" + FoldDiacritics(strings.Join(strings.Fields(word), " "))
+}
+
+// LookupDictionary returns the entries for a word in a language, in file order.
+func LookupDictionary(lang string, word string) []DictionaryEntry {
+
+	key := getDictionaryKey(lang, word)
+
+	var entries []DictionaryEntry
+
+	i := sort.Search(len(dictionary.keys), func(i int) bool {
+		return dictionary.keys[i].key >= key
+	})
+	for ; i < len(dictionary.keys) && dictionary.keys[i].key == key; i++ {
+		line := make([]byte, dictionary.keys[i].end-dictionary.keys[i].start)
+		if _, err := dictionary.file.ReadAt(line, dictionary.keys[i].start); err != nil {
+			log.Printf("Dictionary: %s", err)
+			continue
+		}
+
+		var entry DictionaryEntry
+		if err := json.Unmarshal(line, &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// diacriticsFolding maps letters with diacritics to their base letters, for all the languages
+// of the interface. Combining marks, like Arabic harakat, are removed separately.
+var diacriticsFolding = func() map[rune]string {
+
+	table := map[string]string{
+		"a":  "àáâãäåāăąǎạảấầẩẫậắằẳẵặ",
+		"c":  "çćĉċč",
+		"d":  "ďđ",
+		"e":  "èéêëēĕėęěẹẻẽếềểễệ",
+		"g":  "ĝğġģ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįıǐỉị",
+		"j":  "ĵ",
+		"k":  "ķ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏőơǒọỏốồổỗộớờởỡợ",
+		"r":  "ŕŗř",
+		"s":  "śŝşšș",
+		"t":  "ţťŧț",
+		"u":  "ùúûüũūŭůűųưǔụủứừửữự",
+		"w":  "ŵ",
+		"y":  "ýÿŷỳỵỷỹ",
+		"z":  "źżž",
+		"ae": "æ",
+		"oe": "œ",
+		"ss": "ß",
+		"е":  "ё",
+		"и":  "й",
+		"ا":  "أإآٱ",
+		"ه":  "ة",
+		"ي":  "ى",
+	}
+
+	folding := make(map[rune]string)
+	for base, letters := range table {
+		for _, r := range letters {
+			folding[r] = base
+		}
+	}
+	return folding
+}()
+
+// FoldDiacritics lowercases a string and removes its diacritics.
+func FoldDiacritics(s string) string {
+
+	var folded bytes.Buffer
+
+	for _, r := range strings.ToLower(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if base, ok := diacriticsFolding[r]; ok {
+			folded.WriteString(base)
+		} else {
+			folded.WriteRune(r)
+		}
+	}
+
+	return folded.String()
+}
+
+// definitionAffix turns a query into a dictionary lookup, with a confidence.
+type definitionAffix struct {
+	affix      string
+	confidence float64
+}
+
+// definitionPrefixes and definitionSuffixes are matched in order on folded queries.
+var definitionPrefixes = []definitionAffix{
+	{"define:", 1},
+	{"define ", 0.9},
+	{"definition of ", 0.9},
+	{"meaning of ", 0.9},
+	{"definition de ", 0.9},
+	{"definition ", 0.8},
+	{"definicion de ", 0.9},
+	{"significado de ", 0.9},
+	{"bedeutung von ", 0.9},
+	{"significato di ", 0.9},
+	{"betekenis van ", 0.9},
+}
+
+var definitionSuffixes = []definitionAffix{
+	{" meaning", 0.8},
+	{" definition", 0.8},
+	{" definicion", 0.8},
+	{" bedeutung", 0.8},
+	{" significado", 0.8},
+	{" significato", 0.8},
+	{" betekenis", 0.8},
+}
+
+// getDefinitionWord returns the word to define in a query and our confidence, or an empty string.
+func getDefinitionWord(q string) (string, float64) {
+
+	folded := FoldDiacritics(strings.Join(strings.Fields(q), " "))
+
+	for _, prefix := range definitionPrefixes {
+		if strings.HasPrefix(folded, prefix.affix) {
+			return strings.TrimSpace(folded[len(prefix.affix):]), prefix.confidence
+		}
+	}
+	for _, suffix := range definitionSuffixes {
+		if strings.HasSuffix(folded, suffix.affix) {
+			return strings.TrimSpace(folded[:len(folded)-len(suffix.affix)]), suffix.confidence
+		}
+	}
+
+	return "", 0
+}
+
+// DictionaryAnswer is the Data of define answers.
+type DictionaryAnswer struct {
+	Word   string            `json:"w"`
+	Lang   string            `json:"g"`
+	Senses []DictionarySense `json:"s"`
+}
+
+// dictionaryProvider answers "define:word" and "word meaning" queries from Config.DictionaryPath.
+type dictionaryProvider struct{}
+
+func init() {
+	RegisterAnswerProvider(dictionaryProvider{}, 20*time.Millisecond)
+}
+
+func (dictionaryProvider) Name() string {
+	return "define"
+}
+
+func (dictionaryProvider) Match(req SearchRequest) bool {
+	word, _ := getDefinitionWord(req.Query)
+	return word != "" && len(dictionary.keys) > 0
+}
+
+func (dictionaryProvider) Answer(ctx context.Context, req SearchRequest) (*Answer, error) {
+
+	word, confidence := getDefinitionWord(req.Query)
+
+	// Without a search language, we look in all of them.
+	langs := []string{req.Lang}
+	if req.Lang == "all" || req.Lang == "" {
+		langs = SearchLanguages
+	}
+
+	for _, lang := range langs {
+		entries := LookupDictionary(lang, word)
+		if len(entries) == 0 {
+			continue
+		}
+
+		entry := entries[0]
+		if len(entry.Senses) > maxDictionarySenses {
+			entry.Senses = entry.Senses[:maxDictionarySenses]
+		}
+
+		return &Answer{
+			Confidence: confidence,
+			Data:       DictionaryAnswer{Word: entry.Word, Lang: entry.Lang, Senses: entry.Senses},
+		}, nil
+	}
+
+	return nil, nil
+}
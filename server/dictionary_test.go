@@ -0,0 +1,139 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testDictionary = `{"lang": "en", "word": "house", "senses": [{"pos": "noun", "definition": "A building for living in.", "examples": ["They bought a house."]}, {"pos": "verb", "definition": "To give shelter to."}]}
+{"lang": "fr", "word": "Café", "senses": [{"pos": "nom", "definition": "Boisson obtenue à partir de graines torréfiées."}]}
+not json
+{"lang": "vi", "word": "Việt Nam", "senses": [{"definition": "Quốc gia ở Đông Nam Á."}]}
+{"lang": "de", "word": "Straße", "senses": [{"pos": "Substantiv", "definition": "Befestigter Verkehrsweg."}]}` + "\r\n" +
+	`{"lang": "ru", "word": "ёлка", "senses": [{"definition": "Новогоднее дерево."}]}
+`
+
+// loadTestDictionary writes the test dictionary to a temporary gzipped file and loads it.
+func loadTestDictionary(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cosr-dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "dictionary.jsonl.gz")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write([]byte(testDictionary)); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	file.Close()
+
+	defer func(v string) { Config.DictionaryPath = v }(Config.DictionaryPath)
+	Config.DictionaryPath = filename
+
+	LoadDictionary()
+}
+
+func TestFoldDiacritics(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"Café":     "cafe",
+		"Straße":   "strasse",
+		"Việt Nam": "viet nam",
+		"Łódź":     "lodz",
+		"Ёлка":     "елка",
+		"café":    "cafe",
+		"مُحَمَّد": "محمد",
+		"東京":       "東京",
+	}
+
+	for s, want := range tests {
+		if got := FoldDiacritics(s); got != want {
+			t.Errorf("FoldDiacritics(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestDictionary(t *testing.T) {
+
+	loadTestDictionary(t)
+
+	if len(dictionary.keys) != 5 {
+		t.Fatalf("Should skip invalid lines: %d entries", len(dictionary.keys))
+	}
+
+	entries := LookupDictionary("fr", "CAFE")
+	if len(entries) != 1 || entries[0].Word != "Café" || entries[0].Senses[0].PartOfSpeech != "nom" {
+		t.Fatalf("Lookup should ignore case and diacritics: %#v", entries)
+	}
+
+	if len(LookupDictionary("en", "café")) != 0 {
+		t.Fatal("Lookup should be restricted to a language")
+	}
+
+	if len(LookupDictionary("de", "strasse")) != 1 || len(LookupDictionary("ru", "елка")) != 1 {
+		t.Fatal("Lookup should work for all languages")
+	}
+
+	tests := []struct {
+		q, lang, word string
+	}{
+		{"define:house", "en", "house"},
+		{"house meaning", "en", "house"},
+		{"Définition de café", "fr", "Café"},
+		{"define:café", "fr", "Café"},
+		{"define: viet nam", "all", "Việt Nam"},
+		{"house", "en", ""},
+		{"define:mansion", "en", ""},
+	}
+
+	for _, test := range tests {
+		word := ""
+		answer := <-SearchRequest{Query: test.q, Lang: test.lang, Page: 1}.StartAnswers()
+		if answer != nil && answer.Type == "define" {
+			word = answer.Data.(DictionaryAnswer).Word
+		}
+		if word != test.word {
+			t.Errorf("Definition of %q in %s = %q, want %q", test.q, test.lang, word, test.word)
+		}
+	}
+
+	answer := <-SearchRequest{Query: "define:house", Lang: "en", Page: 1}.StartAnswers()
+	if !strings.Contains(answer.HTML, "<i>noun</i>&nbsp;A building for living in.") || !strings.Contains(answer.HTML, "They bought a house.") {
+		t.Fatalf("Should render parts of speech and examples: %s", answer.HTML)
+	}
+}
+
+func TestDictionaryLongEntry(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "cosr-dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	line := `{"lang": "en", "word": "long", "senses": [{"definition": "` + strings.Repeat("x", maxDictionaryLineLength) + `"}]}`
+	if _, err := file.WriteString(testDictionary + line + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := dictionary.keys
+	if _, err := loadDictionary(file); err == nil {
+		t.Fatal("Should reject entries longer than maxDictionaryLineLength")
+	}
+	if len(dictionary.keys) != len(keys) {
+		t.Fatal("Failed loads should keep the current dictionary")
+	}
+}
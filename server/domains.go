@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/commonsearch/cosr-front/server/query"
+	"gopkg.in/olivere/elastic.v3"
+	"net/url"
+	"strings"
+)
+
+// DomainMore links to the hits from a domain that were collapsed on a result page.
+type DomainMore struct {
+	Domain string `json:"d"`
+	Count  int    `json:"c"`
+	Href   string `json:"h"`
+}
+
+// DomainFacet is the number of documents matching a search in one domain.
+type DomainFacet struct {
+	Domain string `json:"d"`
+	Count  int64  `json:"c"`
+	Href   string `json:"h"`
+}
+
+// GetDomain returns the domain of a URL, without its "www." prefix.
+func GetDomain(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(strings.Split(parsed.Host, ":")[0]), "www.")
+}
+
+// SiteHref returns the relative URL of this search, restricted to a domain.
+func (req SearchRequest) SiteHref(domain string) string {
+	return req.WithQuery(query.FieldSite + ":" + domain + " " + req.Query).Href()
+}
+
+// hasSiteOperator returns true if the search is already restricted to a domain.
+func (req SearchRequest) hasSiteOperator() bool {
+	for _, operator := range req.Operators {
+		if operator.Name == query.FieldSite && !operator.Negated {
+			return true
+		}
+	}
+	return false
+}
+
+// CollapseDomains keeps at most Config.MaxHitsPerDomain hits per domain. The last kept hit
+// of each collapsed domain links to a site-restricted search for the others.
+func (req SearchRequest) CollapseDomains(hits []Hit) []Hit {
+
+	if Config.MaxHitsPerDomain <= 0 || req.hasSiteOperator() {
+		return hits
+	}
+
+	kept := make([]Hit, 0, len(hits))
+	keptCount := make(map[string]int)
+	lastKept := make(map[string]int)
+	collapsed := make(map[string]int)
+	var collapsedDomains []string
+
+	for _, hit := range hits {
+		domain := GetDomain(hit.URL)
+
+		if domain != "" && keptCount[domain] >= Config.MaxHitsPerDomain {
+			if collapsed[domain] == 0 {
+				collapsedDomains = append(collapsedDomains, domain)
+			}
+			collapsed[domain]++
+			continue
+		}
+
+		keptCount[domain]++
+		lastKept[domain] = len(kept)
+		kept = append(kept, hit)
+	}
+
+	for _, domain := range collapsedDomains {
+		kept[lastKept[domain]].More = &DomainMore{
+			Domain: domain,
+			Count:  collapsed[domain],
+			Href:   req.SiteHref(domain),
+		}
+	}
+
+	return kept
+}
+
+// buildDomainFacetsAggregation returns the aggregation of the top domains of a search.
+func buildDomainFacetsAggregation() esObject {
+	return esObject{"terms": esObject{
+		"field": Config.DomainFacetField,
+		"size":  Config.DomainFacets,
+	}}
+}
+
+// GetDomainFacets reads the domain aggregation from a text search result.
+func (req SearchRequest) GetDomainFacets(textSearchResult *elastic.SearchResult) []DomainFacet {
+
+	terms, found := textSearchResult.Aggregations.Terms("domains")
+	if !found {
+		return nil
+	}
+
+	var facets []DomainFacet
+	for _, bucket := range terms.Buckets {
+		domain, isString := bucket.Key.(string)
+		if !isString {
+			continue
+		}
+		facets = append(facets, DomainFacet{
+			Domain: domain,
+			Count:  bucket.DocCount,
+			Href:   req.SiteHref(domain),
+		})
+	}
+	return facets
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGetDomain(t *testing.T) {
+	t.Parallel()
+
+	if GetDomain("http://www.Example.com:8080/page") != "example.com" {
+		t.Fatal("Wrong domain")
+	}
+
+	if GetDomain("https://sub.example.com") != "sub.example.com" {
+		t.Fatal("Wrong domain")
+	}
+}
+
+func TestCollapseDomains(t *testing.T) {
+
+	defer func(max int) { Config.MaxHitsPerDomain = max }(Config.MaxHitsPerDomain)
+	Config.MaxHitsPerDomain = 2
+
+	hits := []Hit{
+		{ID: "1", URL: "http://a.com/1"},
+		{ID: "2", URL: "http://www.a.com/2"},
+		{ID: "3", URL: "http://b.com/3"},
+		{ID: "4", URL: "http://a.com/4"},
+		{ID: "5", URL: "http://a.com/5"},
+	}
+
+	req := SearchRequest{Query: "x", Lang: "en"}
+	collapsed := req.CollapseDomains(hits)
+
+	if len(collapsed) != 3 || collapsed[2].ID != "3" {
+		t.Fatalf("Wrong collapsed hits %v", collapsed)
+	}
+
+	if collapsed[0].More != nil || collapsed[2].More != nil {
+		t.Fatal("Only the last hit of a collapsed domain should link to the others")
+	}
+
+	if *collapsed[1].More != (DomainMore{Domain: "a.com", Count: 2, Href: "/?g=en&q=site%3Aa.com+x"}) {
+		t.Fatalf("Wrong link to collapsed hits %v", collapsed[1].More)
+	}
+
+	restricted := req.WithQuery("site:a.com x")
+	if len(restricted.CollapseDomains(hits)) != 5 {
+		t.Fatal("Searches restricted to a site shouldn't be collapsed")
+	}
+}
+
+func TestDomainFacetsRequest(t *testing.T) {
+
+	defer func(size int) { Config.DomainFacets = size }(Config.DomainFacets)
+	Config.DomainFacets = 5
+
+	body, err := (SearchRequest{Query: "x", Lang: "en", Page: 1}).BuildTextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+		t.Fatalf("Invalid JSON %s", body)
+	}
+
+	if !strings.Contains(body, `"aggs": {"domains":{"terms":{"field":"domain","size":5}}}`) {
+		t.Fatalf("Missing domain facets in %s", body)
+	}
+
+	body, _ = (SearchRequest{Query: "x", Lang: "en", Page: 2}).BuildTextRequest()
+	if strings.Contains(body, `"aggs"`) {
+		t.Fatal("Domain facets are only needed on the first page")
+	}
+}
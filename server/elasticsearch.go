@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"gopkg.in/olivere/elastic.v3"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"time"
@@ -15,6 +16,20 @@ var ElasticsearchTextClient *elastic.Client
 // ElasticsearchDocsClient is the ES client to the document store.
 var ElasticsearchDocsClient *elastic.Client
 
+// ElasticsearchAutocompleteClient is an ES client to the main index, with a tight timeout.
+var ElasticsearchAutocompleteClient *elastic.Client
+
+// SearchBackend is a pair of text and docs indexes searched by requests.
+type SearchBackend struct {
+	TextClient *elastic.Client
+	DocsClient *elastic.Client
+	TextIndex  string
+	DocsIndex  string
+}
+
+// ShadowBackend is the candidate backend searched by shadow requests, see Config.ShadowSampleRate
+var ShadowBackend *SearchBackend
+
 // ElasticsearchConnect sets up persistent connections to both ES servers.
 func ElasticsearchConnect() {
 
@@ -22,17 +37,40 @@ func ElasticsearchConnect() {
 
 	ElasticsearchDocsClient = ElasticsearchConnectServer(Config.ElasticsearchDocs)
 
+	ElasticsearchAutocompleteClient = ElasticsearchConnectServer(
+		Config.ElasticsearchText,
+		elastic.SetHttpClient(&http.Client{Timeout: time.Duration(Config.AutocompleteTimeoutMs) * time.Millisecond}))
+
+	if Config.ShadowSampleRate > 0 {
+		textURL := Config.ShadowElasticsearchText
+		if textURL == "" {
+			textURL = Config.ElasticsearchText
+		}
+		docsURL := Config.ShadowElasticsearchDocs
+		if docsURL == "" {
+			docsURL = Config.ElasticsearchDocs
+		}
+		shadowHTTPClient := elastic.SetHttpClient(&http.Client{Timeout: time.Duration(Config.ShadowTimeoutMs) * time.Millisecond})
+
+		ShadowBackend = &SearchBackend{
+			TextClient: ElasticsearchConnectServer(textURL, shadowHTTPClient),
+			DocsClient: ElasticsearchConnectServer(docsURL, shadowHTTPClient),
+			TextIndex:  Config.ShadowTextIndex,
+			DocsIndex:  Config.ShadowDocsIndex,
+		}
+	}
+
 }
 
 // ElasticsearchConnectServer connects one single client to its ES server.
-func ElasticsearchConnectServer(url string) *elastic.Client {
+func ElasticsearchConnectServer(url string, options ...elastic.ClientOptionFunc) *elastic.Client {
 
-	client, err := elastic.NewClient(
+	client, err := elastic.NewClient(append([]elastic.ClientOptionFunc{
 		elastic.SetSniff(false),
 		elastic.SetURL(url),
 		elastic.SetHealthcheck(false),
 		elastic.SetSniff(false),
-		elastic.SetErrorLog(log.New(os.Stderr, "ELASTIC: ", log.LstdFlags)))
+		elastic.SetErrorLog(log.New(os.Stderr, "ELASTIC: ", log.LstdFlags))}, options...)...)
 
 	// elastic.SetTraceLog(log.New(os.Stderr, "ELASTIC: ", log.LstdFlags)),
 	// elastic.SetInfoLog(log.New(os.Stdout, "", log.LstdFlags))
@@ -53,11 +91,36 @@ func ElasticsearchConnectServer(url string) *elastic.Client {
 
 }
 
+// getBackend returns the backend searched by a request: the main indexes, unless it is a shadow request.
+func (req SearchRequest) getBackend() *SearchBackend {
+	if req.Backend != nil {
+		return req.Backend
+	}
+	return &SearchBackend{
+		TextClient: ElasticsearchTextClient,
+		DocsClient: ElasticsearchDocsClient,
+		TextIndex:  "text",
+		DocsIndex:  "docs",
+	}
+}
+
 // ElasticsearchRequest sends a POST request to an ES server and parses the returned JSON.
 func ElasticsearchRequest(client *elastic.Client, path string, body string) (*elastic.SearchResult, time.Duration, error) {
 
+	ret := new(elastic.SearchResult)
+
+	took, err := ElasticsearchRequestInto(client, path, body, ret)
+	if err != nil {
+		return nil, took, err
+	}
+	return ret, took, nil
+}
+
+// ElasticsearchRequestInto sends a POST request to an ES server and parses the returned JSON into ret.
+func ElasticsearchRequestInto(client *elastic.Client, path string, body string, ret interface{}) (time.Duration, error) {
+
 	if client == nil {
-		return nil, 0, elastic.ErrNoClient
+		return 0, elastic.ErrNoClient
 	}
 
 	// This is measured on our side in addition to the ElasticSearch-provided SearchResult.TookInMillis
@@ -67,13 +130,11 @@ func ElasticsearchRequest(client *elastic.Client, path string, body string) (*el
 	params := make(url.Values)
 	res, err := client.PerformRequest("POST", path, params, body)
 	if err != nil {
-		return nil, time.Since(start), err
+		return time.Since(start), err
 	}
 
-	ret := new(elastic.SearchResult)
-
 	if err := json.Unmarshal(res.Body, ret); err != nil {
-		return nil, time.Since(start), err
+		return time.Since(start), err
 	}
-	return ret, time.Since(start), nil
+	return time.Since(start), nil
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Judgments grade the relevance of URLs from 0 (irrelevant) to 3 (perfect).
+const (
+	minJudgmentGrade = 0
+	maxJudgmentGrade = 3
+)
+
+// Judgment is the relevance grade of a URL for a query, from 0 (irrelevant) to 3 (perfect).
+type Judgment struct {
+	Query string
+	Lang  string
+	URL   string
+	Grade int
+}
+
+// EvalQuery holds the metrics of a single query.
+type EvalQuery struct {
+	Query     string  `json:"q"`
+	Lang      string  `json:"g"`
+	NDCG      float64 `json:"ndcg"`
+	MRR       float64 `json:"mrr"`
+	Precision float64 `json:"precision"`
+}
+
+// EvalRun holds the metrics of all the queries with a ranking profile, and their means.
+type EvalRun struct {
+	Profile   string      `json:"profile"`
+	Queries   []EvalQuery `json:"queries"`
+	NDCG      float64     `json:"ndcg"`
+	MRR       float64     `json:"mrr"`
+	Precision float64     `json:"precision"`
+}
+
+// EvalReport is the output of the eval command.
+type EvalReport struct {
+	K       int      `json:"k"`
+	Run     EvalRun  `json:"run"`
+	Compare *EvalRun `json:"compare,omitempty"`
+
+	// Regression is true if the compared profile loses more than the threshold of NDCG.
+	Regression bool `json:"regression"`
+}
+
+// evalQueryKey groups judgments by query and language.
+type evalQueryKey struct {
+	query string
+	lang  string
+}
+
+// EvalCommand runs "cosr-front eval [flags] judgments.tsv" and returns the exit code of the process.
+// The judgments file has one "query<TAB>lang<TAB>url<TAB>grade" per line.
+func EvalCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	k := flags.Int("k", 10, "number of results evaluated for each query, up to the size of a result page")
+	profile := flags.String("profile", "", "ranking profile to evaluate, defaults to the profile of each language")
+	compare := flags.String("compare", "", "ranking profile to compare with the first one")
+	format := flags.String("format", "text", "output format: text or json")
+	threshold := flags.Float64("threshold", 0.01, "NDCG loss of the compared profile considered a regression")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *k < 1 || (*format != "text" && *format != "json") {
+		fmt.Fprintln(stderr, "Usage: cosr-front eval [flags] judgments.tsv")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	// Only the first result page is evaluated: P@k would count the hits beyond it as irrelevant.
+	if *k > Config.ResultPageSize {
+		fmt.Fprintf(stderr, "-k can't be larger than the result page size (%d)\n", Config.ResultPageSize)
+		return 2
+	}
+
+	for _, name := range []string{*profile, *compare} {
+		if name != "" && !IsValidRankingProfile(name) {
+			fmt.Fprintf(stderr, "Unknown ranking profile %q\n", name)
+			return 2
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer file.Close()
+
+	judgments, err := ParseJudgments(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	report := EvalReport{K: *k}
+
+	report.Run, err = EvaluateRanking(judgments, *profile, *k)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if *compare != "" {
+		run, err := EvaluateRanking(judgments, *compare, *k)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		report.Compare = &run
+		report.Regression = run.NDCG < report.Run.NDCG-*threshold
+	}
+
+	if *format == "json" {
+		if err := json.NewEncoder(stdout).Encode(report); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	} else {
+		report.WriteText(stdout)
+	}
+
+	if report.Regression {
+		return 1
+	}
+	return 0
+}
+
+// ParseJudgments reads judgments in "query<TAB>lang<TAB>url<TAB>grade" lines. Empty lines and
+// lines starting with # are ignored.
+func ParseJudgments(reader io.Reader) ([]Judgment, error) {
+
+	var judgments []Judgment
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, "\t")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("line %d: expected query, lang, url and grade separated by tabs", line)
+		}
+
+		grade, err := strconv.Atoi(strings.TrimSpace(parts[3]))
+		if err != nil || grade < minJudgmentGrade || grade > maxJudgmentGrade {
+			return nil, fmt.Errorf("line %d: invalid grade %q", line, parts[3])
+		}
+
+		judgments = append(judgments, Judgment{
+			Query: strings.TrimSpace(parts[0]),
+			Lang:  strings.TrimSpace(parts[1]),
+			URL:   normalizeJudgedURL(strings.TrimSpace(parts[2])),
+			Grade: grade,
+		})
+	}
+
+	return judgments, scanner.Err()
+}
+
+// normalizeJudgedURL makes URLs comparable between judgments and hits.
+func normalizeJudgedURL(raw string) string {
+	if u, _ := NormalizeURL(raw); u != nil {
+		return u.String()
+	}
+	return raw
+}
+
+// EvaluateRanking runs the judged queries with a ranking profile and measures their results.
+func EvaluateRanking(judgments []Judgment, profile string, k int) (EvalRun, error) {
+
+	run := EvalRun{Profile: profile}
+
+	grades := make(map[evalQueryKey]map[string]int)
+	var keys []evalQueryKey
+	for _, judgment := range judgments {
+		key := evalQueryKey{judgment.Query, judgment.Lang}
+		if grades[key] == nil {
+			grades[key] = make(map[string]int)
+			keys = append(keys, key)
+		}
+		grades[key][judgment.URL] = judgment.Grade
+	}
+
+	for _, key := range keys {
+
+		req := SearchRequest{
+			Query:      key.query,
+			Lang:       key.lang,
+			Page:       1,
+			Profile:    profile,
+			SafeSearch: Config.SafeSearch,
+		}
+		req.Operators = GetSearchOperators(req.Query)
+
+		result, err := req.PerformSearch()
+		if err != nil {
+			return run, fmt.Errorf("query %q: %s", key.query, err)
+		}
+
+		var urls []string
+		if result.Pinned != nil {
+			urls = append(urls, result.Pinned.URL)
+		}
+		for _, hit := range result.Hits {
+			urls = append(urls, hit.URL)
+		}
+
+		metrics := MeasureRanking(urls, grades[key], k)
+		metrics.Query = key.query
+		metrics.Lang = key.lang
+		run.Queries = append(run.Queries, metrics)
+
+		run.NDCG += metrics.NDCG
+		run.MRR += metrics.MRR
+		run.Precision += metrics.Precision
+	}
+
+	if len(run.Queries) > 0 {
+		run.NDCG /= float64(len(run.Queries))
+		run.MRR /= float64(len(run.Queries))
+		run.Precision /= float64(len(run.Queries))
+	}
+
+	return run, nil
+}
+
+// MeasureRanking computes NDCG@k, MRR and precision@k of ranked URLs against graded URLs.
+// URLs without a grade are irrelevant.
+func MeasureRanking(urls []string, grades map[string]int, k int) EvalQuery {
+
+	var metrics EvalQuery
+
+	if len(urls) > k {
+		urls = urls[:k]
+	}
+
+	dcg := 0.0
+	relevant := 0
+	for i, url := range urls {
+		grade := grades[normalizeJudgedURL(url)]
+		dcg += (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(i+2))
+		if grade > 0 {
+			relevant++
+			if metrics.MRR == 0 {
+				metrics.MRR = 1 / float64(i+1)
+			}
+		}
+	}
+
+	// The ideal ranking has the best grades first.
+	var ideal []int
+	for _, grade := range grades {
+		ideal = append(ideal, grade)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ideal)))
+
+	idcg := 0.0
+	for i, grade := range ideal {
+		if i >= k {
+			break
+		}
+		idcg += (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(i+2))
+	}
+
+	if idcg > 0 {
+		metrics.NDCG = dcg / idcg
+	}
+	metrics.Precision = float64(relevant) / float64(k)
+
+	return metrics
+}
+
+// WriteText writes a report as a table, with the differences of the compared profile.
+func (report EvalReport) WriteText(w io.Writer) {
+
+	name := func(profile string) string {
+		if profile == "" {
+			return "default"
+		}
+		return profile
+	}
+
+	fmt.Fprintf(w, "NDCG@%d\tMRR\tP@%d", report.K, report.K)
+	if report.Compare != nil {
+		fmt.Fprintf(w, "\t| NDCG@%d\tMRR\tP@%d", report.K, report.K)
+	}
+	fmt.Fprintf(w, "\tquery\n")
+
+	for i, q := range report.Run.Queries {
+		fmt.Fprintf(w, "%.4f\t%.4f\t%.4f", q.NDCG, q.MRR, q.Precision)
+		if report.Compare != nil {
+			c := report.Compare.Queries[i]
+			fmt.Fprintf(w, "\t| %.4f\t%.4f\t%.4f", c.NDCG, c.MRR, c.Precision)
+		}
+		fmt.Fprintf(w, "\t%s [%s]\n", q.Query, q.Lang)
+	}
+
+	fmt.Fprintf(w, "%.4f\t%.4f\t%.4f", report.Run.NDCG, report.Run.MRR, report.Run.Precision)
+	if report.Compare != nil {
+		fmt.Fprintf(w, "\t| %.4f\t%.4f\t%.4f", report.Compare.NDCG, report.Compare.MRR, report.Compare.Precision)
+	}
+	fmt.Fprintf(w, "\tOVERALL (%d queries)\n", len(report.Run.Queries))
+
+	if report.Compare != nil {
+		fmt.Fprintf(w, "\n%s vs %s: NDCG %+.4f, MRR %+.4f, P@%d %+.4f\n",
+			name(report.Compare.Profile), name(report.Run.Profile),
+			report.Compare.NDCG-report.Run.NDCG, report.Compare.MRR-report.Run.MRR,
+			report.K, report.Compare.Precision-report.Run.Precision)
+		if report.Regression {
+			fmt.Fprintf(w, "REGRESSION: %s loses NDCG\n", name(report.Compare.Profile))
+		}
+	}
+}
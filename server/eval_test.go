@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMeasureRanking(t *testing.T) {
+	t.Parallel()
+
+	grades := map[string]int{"http://a.com/": 3, "http://b.com/": 1}
+
+	perfect := MeasureRanking([]string{"http://a.com/", "http://b.com/", "http://c.com/"}, grades, 10)
+	if perfect.NDCG != 1 || perfect.MRR != 1 || perfect.Precision != 0.2 {
+		t.Fatalf("Wrong metrics %+v", perfect)
+	}
+
+	// DCG = 1/log2(3) + 7/log2(4), IDCG = 7 + 1/log2(3)
+	swapped := MeasureRanking([]string{"http://c.com/", "http://b.com/", "http://a.com"}, grades, 10)
+	if math.Abs(swapped.NDCG-(1/math.Log2(3)+3.5)/(7+1/math.Log2(3))) > 1e-9 || swapped.MRR != 0.5 {
+		t.Fatalf("Wrong metrics %+v", swapped)
+	}
+
+	if cut := MeasureRanking([]string{"http://c.com/", "http://a.com/"}, grades, 1); cut.NDCG != 0 || cut.MRR != 0 || cut.Precision != 0 {
+		t.Fatalf("Should only consider the first k results %+v", cut)
+	}
+}
+
+func TestParseJudgments(t *testing.T) {
+	t.Parallel()
+
+	judgments, err := ParseJudgments(strings.NewReader("# query\tlang\turl\tgrade\n\nfoo bar\ten\tExample.com\t2\n"))
+	if err != nil || len(judgments) != 1 || judgments[0] != (Judgment{"foo bar", "en", "http://example.com/", 2}) {
+		t.Fatalf("Wrong judgments %v %v", judgments, err)
+	}
+
+	for _, grade := range []string{"good", "-1", "4", "10"} {
+		if _, err := ParseJudgments(strings.NewReader("foo\ten\thttp://example.com/\t" + grade + "\n")); err == nil {
+			t.Fatalf("Should reject invalid grade %s", grade)
+		}
+	}
+}
+
+func TestEvalCommand(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "judgments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("xxxteststring\ten\thttp://www.example.com/page/2\t3\n")
+	file.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := EvalCommand([]string{"-format", "json", "-compare", "text", file.Name()}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Wrong exit code %d: %s", code, stderr.String())
+	}
+
+	var report EvalReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Run.MRR != 0.5 || report.Run.Precision != 0.1 || report.Compare == nil || report.Regression {
+		t.Fatalf("Wrong report %+v", report)
+	}
+
+	stdout.Reset()
+	EvalCommand([]string{file.Name()}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "0.6309\t0.5000\t0.1000\tOVERALL (1 queries)") {
+		t.Fatalf("Wrong text report %s", stdout.String())
+	}
+
+	if EvalCommand([]string{"-compare", "xxx", file.Name()}, &stdout, &stderr) != 2 {
+		t.Fatal("Should reject unknown profiles")
+	}
+
+	if EvalCommand([]string{"-k", "1000", file.Name()}, &stdout, &stderr) != 2 {
+		t.Fatal("Should reject k larger than a result page")
+	}
+}
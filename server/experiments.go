@@ -0,0 +1,502 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/olivere/elastic.v3"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Experiment compares two ranking profiles on real traffic with team-draft interleaving: the hits
+// of both profiles are merged in a single result page, and the profile whose hits get more clicks
+// wins the impression. Experiments are defined in Config.Experiments.
+type Experiment struct {
+	Name     string `json:"name"`
+	ProfileA string `json:"a"`
+	ProfileB string `json:"b"`
+
+	// Share is the percentage of sessions in the experiment.
+	Share int `json:"share"`
+}
+
+// ExperimentImpression identifies an interleaved result page, for click beacons.
+type ExperimentImpression struct {
+	Experiment string `json:"e"`
+	Token      string `json:"i"`
+}
+
+// ExperimentStats are the aggregated results of an experiment.
+type ExperimentStats struct {
+	Experiment
+
+	// Impressions is the number of interleaved result pages served.
+	Impressions int64 `json:"impressions"`
+
+	// Clicks is the number of clicks on interleaved hits.
+	Clicks int64 `json:"clicks"`
+
+	// WinsA and WinsB are the numbers of clicked impressions where a profile got more clicks than the other.
+	WinsA int64 `json:"wins_a"`
+	WinsB int64 `json:"wins_b"`
+	Ties  int64 `json:"ties"`
+}
+
+// Teams of interleaved hits
+const (
+	TeamA = "a"
+	TeamB = "b"
+)
+
+// experimentSessionCookie holds a random session ID. It isn't linked to anything else and
+// expires with the browser session.
+const experimentSessionCookie = "sid"
+
+// experimentImpressionTTL is the time during which clicks on an impression are counted together.
+const experimentImpressionTTL = 30 * time.Minute
+
+// maxPendingImpressions bounds the memory used by impressions that are still being clicked.
+const maxPendingImpressions = 100000
+
+// experiments are the running experiments, parsed from Config.Experiments.
+var experiments []Experiment
+
+// experimentSecret signs impression tokens.
+var experimentSecret []byte
+
+// pendingImpression counts the clicks of an impression until experimentImpressionTTL.
+type pendingImpression struct {
+	experiment string
+	start      time.Time
+	clicks     map[string]int
+
+	// clicked are the positions of the hits already clicked.
+	clicked map[int]bool
+}
+
+// experimentResults are the stats of all experiments, with the impressions still being clicked.
+var experimentResults = struct {
+	sync.Mutex
+	stats   map[string]*ExperimentStats
+	pending map[string]*pendingImpression
+}{
+	stats:   make(map[string]*ExperimentStats),
+	pending: make(map[string]*pendingImpression),
+}
+
+// LoadExperiments parses Config.Experiments at startup, like "name:profileA:profileB:share,...".
+func LoadExperiments() {
+
+	parsed, err := parseExperiments(Config.Experiments)
+	if err != nil {
+		log.Fatalf("Invalid experiments: %s", err)
+	}
+	experiments = parsed
+
+	experimentResults.Lock()
+	for _, experiment := range experiments {
+		if experimentResults.stats[experiment.Name] == nil {
+			experimentResults.stats[experiment.Name] = &ExperimentStats{Experiment: experiment}
+		}
+	}
+	experimentResults.Unlock()
+
+	experimentSecret = []byte(Config.ExperimentSecret)
+	if len(experimentSecret) == 0 {
+		experimentSecret = make([]byte, 32)
+		if _, err := rand.Read(experimentSecret); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// parseExperiments validates experiment definitions against the loaded ranking profiles.
+func parseExperiments(definitions string) ([]Experiment, error) {
+
+	var parsed []Experiment
+	total := 0
+
+	for _, definition := range strings.Split(definitions, ",") {
+
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+
+		parts := strings.Split(definition, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("%q should be name:profileA:profileB:share", definition)
+		}
+
+		share, err := strconv.Atoi(parts[3])
+		if err != nil || share <= 0 {
+			return nil, fmt.Errorf("invalid share in %q", definition)
+		}
+		total += share
+
+		for _, profile := range parts[1:3] {
+			if !IsValidRankingProfile(profile) {
+				return nil, fmt.Errorf("unknown ranking profile %q in %q", profile, definition)
+			}
+		}
+
+		for _, other := range parsed {
+			if other.Name == parts[0] {
+				return nil, fmt.Errorf("duplicate experiment %q", parts[0])
+			}
+		}
+
+		parsed = append(parsed, Experiment{Name: parts[0], ProfileA: parts[1], ProfileB: parts[2], Share: share})
+	}
+
+	if total > 100 {
+		return nil, fmt.Errorf("shares add up to %d%%", total)
+	}
+
+	return parsed, nil
+}
+
+// getSessionBucket returns the bucket between 0 and 99 of a session ID.
+func getSessionBucket(sessionID string) int {
+	hash := sha256.Sum256([]byte(sessionID))
+	return int(binary.BigEndian.Uint16(hash[:2]) % 100)
+}
+
+// getExperimentForBucket returns the experiment of a bucket. Experiments take consecutive buckets, so
+// that sessions are in at most one of them.
+func getExperimentForBucket(bucket int) *Experiment {
+
+	start := 0
+	for i := range experiments {
+		if bucket < start+experiments[i].Share {
+			return &experiments[i]
+		}
+		start += experiments[i].Share
+	}
+
+	return nil
+}
+
+// AssignExperiment returns the experiment of the session of a search, giving it a session cookie if needed.
+// Only first pages of default rankings are interleaved.
+func AssignExperiment(w http.ResponseWriter, r *http.Request, req *SearchRequest) *Experiment {
+
+	if len(experiments) == 0 || req.Query == "" || req.Page > 1 || req.Cursor != "" || req.Profile != "" || req.Explain {
+		return nil
+	}
+
+	var sessionID string
+	if cookie, err := r.Cookie(experimentSessionCookie); err == nil && cookie.Value != "" {
+		sessionID = cookie.Value
+	} else {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil
+		}
+		sessionID = hex.EncodeToString(random)
+		cookie := &http.Cookie{
+			Name:     experimentSessionCookie,
+			Value:    sessionID,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(getPublicBaseURL(r), "https:"),
+		}
+
+		// http.Cookie has no SameSite field before Go 1.11.
+		w.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Lax")
+	}
+
+	return getExperimentForBucket(getSessionBucket(sessionID))
+}
+
+// TeamDraftInterleave merges two rankings of IDs. At each round, the team with fewer picks, or
+// the winner of a coin toss, picks its best ID not picked yet. It returns the merged IDs and their teams.
+func TeamDraftInterleave(a []string, b []string, size int, coin func() bool) ([]string, map[string]string) {
+
+	var merged []string
+	teams := make(map[string]string)
+	countA, countB := 0, 0
+	i, j := 0, 0
+
+	for len(merged) < size {
+
+		for i < len(a) && teams[a[i]] != "" {
+			i++
+		}
+		for j < len(b) && teams[b[j]] != "" {
+			j++
+		}
+		if i >= len(a) && j >= len(b) {
+			break
+		}
+
+		pickA := countA < countB || (countA == countB && coin())
+		if j >= len(b) {
+			pickA = true
+		} else if i >= len(a) {
+			pickA = false
+		}
+
+		if pickA {
+			teams[a[i]] = TeamA
+			merged = append(merged, a[i])
+			countA++
+		} else {
+			teams[b[j]] = TeamB
+			merged = append(merged, b[j])
+			countB++
+		}
+	}
+
+	return merged, teams
+}
+
+// performInterleavedTextRequest sends the text requests of both profiles of an experiment, and merges their hits.
+func (req SearchRequest) performInterleavedTextRequest() (*elastic.SearchResult, map[string]string, time.Duration, error) {
+
+	profiles := []string{req.Experiment.ProfileA, req.Experiment.ProfileB}
+	results := make([]*elastic.SearchResult, 2)
+	times := make([]time.Duration, 2)
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i, profile := range profiles {
+		wg.Add(1)
+		go func(i int, variant SearchRequest) {
+			defer wg.Done()
+			results[i], times[i], errs[i] = variant.performTextRequest(nil)
+		}(i, req.withExperimentProfile(profile))
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if times[1] > times[0] {
+		times[0] = times[1]
+	}
+
+	// Facets, counts and spelling suggestions come from the first profile.
+	merged := *results[0]
+	if merged.Hits == nil {
+		merged.Hits = &elastic.SearchHits{}
+	}
+
+	hitsByID := make(map[string]*elastic.SearchHit)
+	ids := make([][]string, 2)
+	for i, result := range results {
+		if result.Hits == nil {
+			continue
+		}
+		for _, hit := range result.Hits.Hits {
+			hitsByID[hit.Id] = hit
+			ids[i] = append(ids[i], hit.Id)
+		}
+	}
+
+	order, teams := TeamDraftInterleave(ids[0], ids[1], Config.ResultPageSize, func() bool { return mathrand.Intn(2) == 0 })
+
+	hits := *merged.Hits
+	hits.Hits = make([]*elastic.SearchHit, len(order))
+	for i, id := range order {
+		hits.Hits[i] = hitsByID[id]
+	}
+	merged.Hits = &hits
+
+	if results[1].TookInMillis > merged.TookInMillis {
+		merged.TookInMillis = results[1].TookInMillis
+	}
+
+	return &merged, teams, times[0], nil
+}
+
+// withExperimentProfile returns the same search with the ranking profile of an experiment.
+func (req SearchRequest) withExperimentProfile(profile string) SearchRequest {
+	other := req
+	other.Profile = profile
+	other.Experiment = nil
+	return other
+}
+
+// signImpression returns the signature of an impression ID, the teams of its hits and its issue time.
+func signImpression(experiment string, id string, teams string, issued string) string {
+	mac := hmac.New(sha256.New, experimentSecret)
+	mac.Write([]byte(experiment + ":" + id + ":" + teams + ":" + issued))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// impressionToken returns the signed token of an impression, like "id.teams.issued.signature".
+func impressionToken(experiment string, id string, teams string, issued time.Time) string {
+	timestamp := strconv.FormatInt(issued.Unix(), 10)
+	return id + "." + teams + "." + timestamp + "." + signImpression(experiment, id, teams, timestamp)
+}
+
+// NewExperimentImpression counts a new interleaved result page, and returns its signed identifier.
+// The token holds the team of each hit, so that clicks only need to send the position of the hit.
+func NewExperimentImpression(experiment string, teams []string) *ExperimentImpression {
+
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return nil
+	}
+	id := hex.EncodeToString(random)
+
+	experimentResults.Lock()
+	if stats := experimentResults.stats[experiment]; stats != nil {
+		stats.Impressions++
+	}
+	experimentResults.Unlock()
+
+	return &ExperimentImpression{Experiment: experiment, Token: impressionToken(experiment, id, strings.Join(teams, ""), time.Now())}
+}
+
+// RecordExperimentClick counts a click on the hit at a position (from 1) of an impression, for the team
+// that picked it. Only the first click on each hit is counted. It returns false if the click is invalid,
+// or if the impression is older than experimentImpressionTTL and its outcome may already be final.
+func RecordExperimentClick(experiment string, token string, position int) bool {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || !hmac.Equal([]byte(parts[3]), []byte(signImpression(experiment, parts[0], parts[1], parts[2]))) {
+		return false
+	}
+	if position < 1 || position > len(parts[1]) {
+		return false
+	}
+	team := parts[1][position-1 : position]
+
+	timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return false
+	}
+	issued := time.Unix(timestamp, 0)
+	now := time.Now()
+	if now.Sub(issued) >= experimentImpressionTTL {
+		return false
+	}
+
+	experimentResults.Lock()
+	defer experimentResults.Unlock()
+
+	stats := experimentResults.stats[experiment]
+	if stats == nil {
+		return false
+	}
+
+	foldExpiredImpressions(now)
+
+	// Impressions expire with their token, so a folded impression can't be clicked again.
+	impression := experimentResults.pending[parts[0]]
+	if impression == nil {
+		if len(experimentResults.pending) >= maxPendingImpressions {
+			return false
+		}
+		impression = &pendingImpression{
+			experiment: experiment,
+			start:      issued,
+			clicks:     make(map[string]int),
+			clicked:    make(map[int]bool),
+		}
+		experimentResults.pending[parts[0]] = impression
+	}
+
+	if impression.clicked[position] {
+		return true
+	}
+	impression.clicked[position] = true
+	impression.clicks[team]++
+	stats.Clicks++
+
+	return true
+}
+
+// foldExpiredImpressions adds the outcome of impressions older than experimentImpressionTTL to the stats.
+// experimentResults must be locked.
+func foldExpiredImpressions(now time.Time) {
+	for id, impression := range experimentResults.pending {
+		if now.Sub(impression.start) >= experimentImpressionTTL {
+			addImpressionOutcome(experimentResults.stats[impression.experiment], impression)
+			delete(experimentResults.pending, id)
+		}
+	}
+}
+
+// addImpressionOutcome counts the winner of a clicked impression.
+func addImpressionOutcome(stats *ExperimentStats, impression *pendingImpression) {
+	if stats == nil {
+		return
+	}
+	switch {
+	case impression.clicks[TeamA] > impression.clicks[TeamB]:
+		stats.WinsA++
+	case impression.clicks[TeamA] < impression.clicks[TeamB]:
+		stats.WinsB++
+	default:
+		stats.Ties++
+	}
+}
+
+// GetExperimentStats returns the current stats of the running experiments, including the
+// impressions that may still be clicked.
+func GetExperimentStats() []ExperimentStats {
+
+	experimentResults.Lock()
+	defer experimentResults.Unlock()
+
+	foldExpiredImpressions(time.Now())
+
+	current := make(map[string]*ExperimentStats, len(experiments))
+	for _, experiment := range experiments {
+		stats := *experimentResults.stats[experiment.Name]
+		current[experiment.Name] = &stats
+	}
+	for _, impression := range experimentResults.pending {
+		addImpressionOutcome(current[impression.experiment], impression)
+	}
+
+	all := make([]ExperimentStats, len(experiments))
+	for i, experiment := range experiments {
+		all[i] = *current[experiment.Name]
+	}
+	return all
+}
+
+// ClickHandler receives the click beacons of interleaved hits (/api/click?e=*&i=*&n=*)
+func ClickHandler(w http.ResponseWriter, r *http.Request) {
+
+	position, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil || !RecordExperimentClick(r.FormValue("e"), r.FormValue("i"), position) {
+		http.Error(w, "Invalid click", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminExperimentsHandler returns the win/loss counts of the experiments (/admin/experiments)
+func AdminExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(GetExperimentStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
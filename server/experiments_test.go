@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTeamDraftInterleave(t *testing.T) {
+	t.Parallel()
+
+	heads := func() bool { return true }
+
+	merged, teams := TeamDraftInterleave([]string{"1", "2", "3"}, []string{"2", "4", "1"}, 10, heads)
+
+	if !reflect.DeepEqual(merged, []string{"1", "2", "3", "4"}) {
+		t.Fatalf("Wrong interleaving %v", merged)
+	}
+
+	if !reflect.DeepEqual(teams, map[string]string{"1": TeamA, "2": TeamB, "3": TeamA, "4": TeamB}) {
+		t.Fatalf("Wrong teams %v", teams)
+	}
+
+	if merged, _ := TeamDraftInterleave([]string{"1", "2"}, []string{"3", "4"}, 3, heads); len(merged) != 3 {
+		t.Fatal("Should stop at the page size")
+	}
+
+	if merged, _ := TeamDraftInterleave(nil, []string{"3", "4"}, 3, heads); !reflect.DeepEqual(merged, []string{"3", "4"}) {
+		t.Fatal("Should pick from the other team when one has no more hits")
+	}
+}
+
+func TestParseExperiments(t *testing.T) {
+	t.Parallel()
+
+	parsed, err := parseExperiments("a:standard:text:10, b:standard:strict:5")
+	if err != nil || len(parsed) != 2 || parsed[1] != (Experiment{Name: "b", ProfileA: "standard", ProfileB: "strict", Share: 5}) {
+		t.Fatalf("Wrong experiments %v %v", parsed, err)
+	}
+
+	for invalid, expected := range map[string]string{
+		"a:standard:text":                     "should be name:profileA:profileB:share",
+		"a:standard:text:x":                   "invalid share",
+		"a:standard:xxx:10":                   `unknown ranking profile "xxx"`,
+		"a:standard:text:10,a:text:strict:10": `duplicate experiment "a"`,
+		"a:standard:text:60,b:text:strict:50": "shares add up to 110%",
+	} {
+		if _, err := parseExperiments(invalid); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestExperimentResults(t *testing.T) {
+
+	defer func(v []Experiment) { experiments = v }(experiments)
+	experiments, _ = parseExperiments("xxxtest:standard:text:10,xxxother:standard:strict:20")
+
+	if getExperimentForBucket(5).Name != "xxxtest" || getExperimentForBucket(29).Name != "xxxother" || getExperimentForBucket(30) != nil {
+		t.Fatal("Wrong buckets")
+	}
+
+	for _, experiment := range experiments {
+		experimentResults.stats[experiment.Name] = &ExperimentStats{Experiment: experiment}
+	}
+
+	impression := NewExperimentImpression("xxxtest", []string{TeamA, TeamB, TeamB})
+
+	if RecordExperimentClick("xxxtest", impression.Token+"0", 1) || RecordExperimentClick("xxxother", impression.Token, 1) {
+		t.Fatal("Should check the impression token")
+	}
+
+	forged := strings.Replace(impression.Token, ".abb.", ".aaa.", 1)
+	if forged == impression.Token || RecordExperimentClick("xxxtest", forged, 2) {
+		t.Fatal("Should check the teams of the impression")
+	}
+
+	if RecordExperimentClick("xxxtest", impression.Token, 0) || RecordExperimentClick("xxxtest", impression.Token, 4) {
+		t.Fatal("Should check the position")
+	}
+
+	if RecordExperimentClick("xxxtest", impressionToken("xxxtest", "0123", TeamA, time.Now().Add(-experimentImpressionTTL)), 1) {
+		t.Fatal("Should reject impressions older than experimentImpressionTTL")
+	}
+
+	RecordExperimentClick("xxxtest", impression.Token, 1)
+	RecordExperimentClick("xxxtest", impression.Token, 2)
+	RecordExperimentClick("xxxtest", impression.Token, 3)
+	RecordExperimentClick("xxxtest", NewExperimentImpression("xxxtest", []string{TeamA}).Token, 1)
+
+	// Only the first click on a hit counts
+	repeated := NewExperimentImpression("xxxtest", []string{TeamA, TeamB})
+	RecordExperimentClick("xxxtest", repeated.Token, 1)
+	RecordExperimentClick("xxxtest", repeated.Token, 1)
+	RecordExperimentClick("xxxtest", repeated.Token, 1)
+	RecordExperimentClick("xxxtest", repeated.Token, 2)
+
+	stats := GetExperimentStats()
+	if stats[0].Impressions != 3 || stats[0].Clicks != 6 || stats[0].WinsA != 1 || stats[0].WinsB != 1 || stats[0].Ties != 1 {
+		t.Fatalf("Wrong stats %+v", stats[0])
+	}
+
+	// Impressions are final after experimentImpressionTTL
+	experimentResults.Lock()
+	foldExpiredImpressions(time.Now().Add(experimentImpressionTTL))
+	pending := len(experimentResults.pending)
+	experimentResults.Unlock()
+
+	if stats := GetExperimentStats(); pending != 0 || stats[0].WinsA != 1 || stats[0].WinsB != 1 || stats[0].Ties != 1 || stats[1].Impressions != 0 {
+		t.Fatalf("Wrong final stats %+v", stats)
+	}
+}
+
+func TestExperimentSessionCookie(t *testing.T) {
+
+	defer func(v []Experiment) { experiments = v }(experiments)
+	experiments, _ = parseExperiments("xxxtest:standard:text:100")
+
+	r := httptest.NewRequest("GET", "/?q=foo", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+
+	if AssignExperiment(w, r, &SearchRequest{Query: "foo", Page: 1}) == nil {
+		t.Fatal("Should assign the experiment")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != experimentSessionCookie || !cookies[0].Secure || !cookies[0].HttpOnly ||
+		!strings.HasSuffix(w.Header().Get("Set-Cookie"), "; SameSite=Lax") {
+		t.Fatalf("Wrong session cookie %+v", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	AssignExperiment(w, httptest.NewRequest("GET", "/?q=foo", nil), &SearchRequest{Query: "foo", Page: 1})
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure {
+		t.Fatal("The session cookie can't be secure over http")
+	}
+}
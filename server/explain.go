@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"gopkg.in/olivere/elastic.v3"
+	"net/http"
+)
+
+// SearchDebug is attached to the results of explain=1 searches, to debug their ranking.
+type SearchDebug struct {
+
+	// TextBody and DocsBody are the Elasticsearch requests we generated.
+	TextBody string `json:"tb"`
+	DocsBody string `json:"db,omitempty"`
+
+	// Profile is the timing breakdown of the text request reported by Elasticsearch.
+	Profile json.RawMessage `json:"pf,omitempty"`
+}
+
+// HitExplanation breaks down the score of a hit into the text score and the factors of the
+// function_score of BuildTextRequest().
+type HitExplanation struct {
+	Score   float64       `json:"sc"`
+	Text    float64       `json:"tx"`
+	Factors []ScoreFactor `json:"f"`
+
+	// Details is the full explanation from Elasticsearch.
+	Details *elastic.SearchExplanation `json:"d,omitempty"`
+}
+
+// ScoreFactor is the value of one scoring function for a hit. Functions that were not applied are 1.
+type ScoreFactor struct {
+	Name  string  `json:"n"`
+	Value float64 `json:"v"`
+}
+
+// profiledSearchResult is a text search result with its Elasticsearch profile.
+type profiledSearchResult struct {
+	elastic.SearchResult
+	Profile json.RawMessage `json:"profile"`
+}
+
+// IsExplainAllowed returns true if a request may ask for ranking explanations.
+func IsExplainAllowed(r *http.Request) bool {
+	return Config.ExplainEnabled || isAdmin(r)
+}
+
+// GetHitExplanation extracts the score factors of a text hit requested with "explain". functions are
+// the names of the scoring functions of the request, in order.
+//
+// Elasticsearch explains a function_score as the product of the query score and of the combined
+// functions. A single function is explained alone, several ones have one explanation each, except
+// filtered functions that didn't match the hit.
+func GetHitExplanation(hit *elastic.SearchHit, functions []string) *HitExplanation {
+
+	if hit.Explanation == nil {
+		return nil
+	}
+
+	root := hit.Explanation
+	explanation := &HitExplanation{
+		Score:   root.Value,
+		Text:    root.Value,
+		Factors: make([]ScoreFactor, len(functions)),
+		Details: root,
+	}
+	for i, name := range functions {
+		explanation.Factors[i] = ScoreFactor{Name: name, Value: 1}
+	}
+
+	// Without functions, the score is the one of the query.
+	if len(functions) == 0 || len(root.Details) != 2 || len(root.Details[1].Details) == 0 {
+		return explanation
+	}
+
+	explanation.Text = root.Details[0].Value
+
+	// The combined functions are the first argument of "min of" the functions and the max boost.
+	combined := root.Details[1].Details[0]
+	if len(functions) == 1 {
+		explanation.Factors[0].Value = combined.Value
+		return explanation
+	}
+
+	for i := range combined.Details {
+		if i < len(explanation.Factors) {
+			explanation.Factors[i].Value = combined.Details[i].Value
+		}
+	}
+
+	return explanation
+}
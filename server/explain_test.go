@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"gopkg.in/olivere/elastic.v3"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetHitExplanation(t *testing.T) {
+	t.Parallel()
+
+	var hit elastic.SearchHit
+	err := json.Unmarshal([]byte(`{"_id": "1", "_explanation": {
+	  "value": 0.6, "description": "function score, product of:", "details": [
+	    {"value": 0.5, "description": "weight(text:house in 0) [PerFieldSimilarity], result of:"},
+	    {"value": 1.2, "description": "min of:", "details": [
+	      {"value": 1.2, "description": "function score, score mode [multiply]", "details": [
+	        {"value": 2, "description": "function score, product of:", "details": [
+	          {"value": 1, "description": "match filter: *:*"},
+	          {"value": 2, "description": "Function for field rank:", "details": [
+	            {"value": 2, "description": "field value function: none(doc['rank'].value?:0.0 * factor=1.0)"}
+	          ]}
+	        ]},
+	        {"value": 0.6, "description": "function score, product of:", "details": [
+	          {"value": 1, "description": "match filter: *:*"},
+	          {"value": 0.6, "description": "Function for field lang_en:", "details": [
+	            {"value": 0.6, "description": "field value function: none(doc['lang_en'].value?:0.002 * factor=1.0)"}
+	          ]}
+	        ]}
+	      ]},
+	      {"value": 3.4028235e+38, "description": "maxBoost"}
+	    ]}
+	  ]}}`), &hit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The filter of the safe search function doesn't match this hit.
+	explanation := GetHitExplanation(&hit, []string{"rank", "lang_en", "adult"})
+
+	if explanation.Score != 0.6 || explanation.Text != 0.5 || !reflect.DeepEqual(explanation.Factors, []ScoreFactor{
+		{"rank", 2}, {"lang_en", 0.6}, {"adult", 1},
+	}) {
+		t.Fatalf("Wrong explanation: %+v", explanation)
+	}
+
+	// A single function is explained alone.
+	err = json.Unmarshal([]byte(`{"_id": "1", "_explanation": {
+	  "value": 0.3, "description": "function score, product of:", "details": [
+	    {"value": 0.5, "description": "weight(text:house in 0) [PerFieldSimilarity], result of:"},
+	    {"value": 0.6, "description": "min of:", "details": [
+	      {"value": 0.6, "description": "field value function: none(doc['lang_en'].value?:0.002 * factor=1.0)"},
+	      {"value": 3.4028235e+38, "description": "maxBoost"}
+	    ]}
+	  ]}}`), &hit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explanation = GetHitExplanation(&hit, []string{"lang_en"})
+	if explanation.Text != 0.5 || !reflect.DeepEqual(explanation.Factors, []ScoreFactor{{"lang_en", 0.6}}) {
+		t.Fatalf("Wrong explanation: %+v", explanation)
+	}
+
+	if GetHitExplanation(&elastic.SearchHit{Id: "2"}, nil) != nil {
+		t.Fatal("Should not explain hits without explanation")
+	}
+
+	names := (SearchRequest{Lang: "all", SafeSearch: SafeSearchModerate}).getScoringFunctionNames()
+	if !reflect.DeepEqual(names, []string{"rank", Config.SafeSearchField}) {
+		t.Fatalf("Wrong scoring functions %v", names)
+	}
+}
+
+func TestExplainRequest(t *testing.T) {
+	t.Parallel()
+
+	body, _ := (SearchRequest{Query: "x", Lang: "en", Page: 1, Explain: true}).BuildTextRequest()
+	if !strings.Contains(body, `"explain": true`) || !strings.Contains(body, `"profile": true`) {
+		t.Fatal("Should ask Elasticsearch for explanations")
+	}
+
+	body, _ = (SearchRequest{Query: "x", Lang: "en", Page: 1}).BuildTextRequest()
+	if strings.Contains(body, `"explain"`) {
+		t.Fatal("Should not ask Elasticsearch for explanations")
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+
+	defer func(v string) { Config.AdminToken = v }(Config.AdminToken)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+
+	Config.AdminToken = ""
+	if isAdmin(r) {
+		t.Fatal("Admin features should be disabled without a token")
+	}
+
+	Config.AdminToken = "secret"
+	if !isAdmin(r) {
+		t.Fatal("Should be admin with the header")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "admin_token=wrong")
+	if isAdmin(r) {
+		t.Fatal("Should not be admin with a wrong token")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/commonsearch/cosr-front/server/query"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,6 +14,16 @@ type resultPage struct {
 	Search SearchRequest `json:"s"`
 	Type   string        `json:"t,omitempty"`
 	Result SearchResult  `json:"r"`
+	Locale *Locale       `json:"-"`
+
+	// Trending are the trending queries on the home page, see GetTrendingQueries()
+	Trending []TrendingQuery `json:"tr,omitempty"`
+}
+
+// apiSearchResult is the JSON API return: the SearchResult, with the interpreted SearchRequest.
+type apiSearchResult struct {
+	*SearchResult
+	Search *SearchRequest `json:"s"`
 }
 
 // getSearchRequest interprets the query in the URL by transforming a http.Request into a SearchRequest.
@@ -23,18 +34,41 @@ func getSearchRequest(r *http.Request) *SearchRequest {
 	sr.Query = strings.Trim(r.FormValue("q"), " ")
 
 	sr.Lang = r.FormValue("g")
+	if !validLangRegexp.MatchString(sr.Lang) {
+		sr.Lang = ""
+	}
 
 	sr.Page, _ = strconv.Atoi(r.FormValue("p"))
 
-	if sr.Page == 0 || sr.Query == "" {
+	sr.Exact = (r.FormValue("x") == "1")
+
+	sr.Explain = (r.FormValue("explain") == "1") && IsExplainAllowed(r)
+
+	sr.Profile = r.FormValue("profile")
+	if !IsValidRankingProfile(sr.Profile) {
+		sr.Profile = ""
+	}
+
+	sr.SafeSearch = getSafeSearch(r)
+
+	sr.UserAgent = r.UserAgent()
+
+	sr.Snippets = r.FormValue("sn")
+	if sr.Snippets != SnippetSummary && sr.Snippets != SnippetHighlight {
+		sr.Snippets = ""
+	}
+
+	if sr.Page < 1 || sr.Query == "" {
 		sr.Page = 1
 	}
 
 	// Keeping Lang empty (=autodetect on client side) is acceptable
 	// only if the query is empty (and we will land on the full homepage)
-	// If we have a query, we unfortunately have to guess an english default
+	// If we have a query, we guess its language, helped by the browser settings.
 	if sr.Lang == "" && sr.Query != "" {
-		sr.Lang = "en"
+		guess := DetectLanguage(sr.Query, r.Header.Get("Accept-Language"))
+		sr.Lang = guess.Lang
+		sr.Detected = &guess
 	}
 
 	err := r.Body.Close()
@@ -50,11 +84,17 @@ func sendResultPage(w http.ResponseWriter, r *http.Request, page *resultPage) {
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
+	// The interface language may come from the browser settings
+	w.Header().Set("Vary", "Accept-Language")
+
 	// If we are in debug mode, read the template from disk at each request!
 	if Config.Debug {
 		LoadTemplates()
+		LoadLocales()
 	}
 
+	page.Locale = GetLocale(r, &page.Search)
+
 	err := Templates["index.html"].ExecuteTemplate(w, "index.html", page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -63,18 +103,24 @@ func sendResultPage(w http.ResponseWriter, r *http.Request, page *resultPage) {
 
 }
 
-// TruncateQuery allows only Config.MaxQueryTerms words to enter the actual search
+// TruncateQuery allows only Config.MaxQueryTerms terms to enter the actual search.
+// Phrases count as a single term.
 func TruncateQuery(q string) (string, string) {
-	words := strings.Fields(q)
-	allowedLength := Config.MaxQueryTerms
 
-	var extra string // the starting word that are truncated
-	if len(words) > allowedLength {
-		q = strings.Join(words[:allowedLength], " ")
-		extra = words[allowedLength]
+	kept, dropped := query.Truncate(query.Parse(q), Config.MaxQueryTerms)
+
+	// Nothing to truncate, keep the query exactly as typed
+	if dropped == nil {
+		return q, ""
+	}
+
+	var truncated string
+	if kept != nil {
+		truncated = kept.String()
 	}
 
-	return q, extra
+	// extra is the first clause that was truncated
+	return truncated, dropped.String()
 }
 
 // SearchHandler handles HTTP queries to home or result pages (/ or /?q=*).
@@ -82,9 +128,21 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 
 	search := getSearchRequest(r)
 
+	setSafeSearchCookie(w, r, search.SafeSearch)
+
+	search.Experiment = AssignExperiment(w, r, search)
+
 	// Empty query: render the "home" version
 	if search.Query == "" {
 		page := resultPage{Type: "home", Search: *search}
+
+		// The language is guessed on the client side, so trending queries are in the interface language.
+		lang := search.Lang
+		if lang == "" {
+			lang = GetLocale(r, search).Lang
+		}
+		page.Trending = GetTrendingQueries(*search, lang, Config.TrendingSize)
+
 		sendResultPage(w, r, &page)
 		return
 	}
@@ -93,6 +151,7 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	if extra != "" {
 		search.Query = truncatedQuery
 	}
+	search.Operators = GetSearchOperators(search.Query)
 
 	// Perform the search itself
 	result, err := search.PerformSearchWithTiming()
@@ -101,6 +160,10 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	LogQuery(search, result)
+	RecordQueryReport(search, result)
+	RecordPopularQuery(r, search, result)
+
 	// If we used a !bang or asked for a redirect, do it now
 	if result.Redirect != "" {
 		http.Redirect(w, r, result.Redirect, 302)
@@ -118,22 +181,45 @@ func APISearchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	search := getSearchRequest(r)
+
+	// Cursors are only available in the API, and replace offset paging.
+	search.Cursor = r.FormValue("cursor")
+	if search.Cursor != "" {
+		search.Page = 1
+	}
+
+	search.Experiment = AssignExperiment(w, r, search)
+
 	truncatedQuery, extra := TruncateQuery(search.Query)
 
 	if extra != "" {
 		search.Query = truncatedQuery
 	}
+	search.Operators = GetSearchOperators(search.Query)
+
+	if _, err := search.GetScrollID(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Perform the search itself
 	result, err := search.PerformSearchWithTiming()
-	result.Extra = extra
+	if err == ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	result.Extra = extra
+
+	LogQuery(search, result)
+	RecordQueryReport(search, result)
+	RecordPopularQuery(r, search, result)
 
 	// Write the result to the client as JSON
-	err = json.NewEncoder(w).Encode(result)
+	err = json.NewEncoder(w).Encode(apiSearchResult{result, search})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
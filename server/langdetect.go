@@ -0,0 +1,315 @@
+package main
+
+import (
+	"github.com/commonsearch/cosr-front/server/query"
+	"io/ioutil"
+	"log"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchLanguages are the languages of the text index, as used in the "g" parameter.
+var SearchLanguages = []string{"ar", "de", "en", "es", "fr", "it", "ja", "ko", "nl", "pl", "pt", "ru", "vi", "zh"}
+
+// defaultLang is chosen when nothing in the request hints at a language.
+const defaultLang = "en"
+
+// LangGuess is the language detected for a query, with a confidence between 0 and 1.
+type LangGuess struct {
+	Lang       string  `json:"g"`
+	Confidence float64 `json:"c"`
+}
+
+// langProfile contains the n-gram log-probabilities of a language.
+type langProfile struct {
+	logProbs map[string]float64
+
+	// unknown is the log-probability of n-grams never seen in the language.
+	unknown float64
+}
+
+// langProfiles are the profiles of languages using the Latin script. Other ones are detected by script.
+var langProfiles = make(map[string]*langProfile)
+
+const (
+	// langNgramMax is the length of the longest n-grams in profiles.
+	langNgramMax = 3
+
+	// langSmoothing is the count added to each n-gram, so that unseen ones don't rule a language out.
+	langSmoothing = 0.1
+
+	// acceptLanguageWeight is the prior weight of a language of the Accept-Language header with q=1,
+	// relative to any other language.
+	acceptLanguageWeight = 20.0
+
+	// langNgramWeight discounts the n-grams of a query: each letter is in up to langNgramMax of them, so
+	// they aren't independent evidence. Without it, a short word would outweigh any prior.
+	langNgramWeight = 1.0 / langNgramMax
+)
+
+// LoadLangProfiles builds language profiles from the sample texts of server/langdata/ at startup.
+func LoadLangProfiles() {
+
+	files, err := filepath.Glob(path.Join(Config.PathFront, "server/langdata/*.txt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, file := range files {
+		cnt, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		langProfiles[strings.TrimSuffix(path.Base(file), ".txt")] = buildLangProfile(string(cnt))
+	}
+}
+
+// buildLangProfile counts the n-grams of a sample text.
+func buildLangProfile(text string) *langProfile {
+
+	counts := make(map[string]int)
+	total := 0
+	for _, ngram := range extractNgrams(text) {
+		counts[ngram]++
+		total++
+	}
+
+	denominator := math.Log(float64(total) + langSmoothing*float64(len(counts)+1))
+
+	profile := &langProfile{
+		logProbs: make(map[string]float64, len(counts)),
+		unknown:  math.Log(langSmoothing) - denominator,
+	}
+	for ngram, count := range counts {
+		profile.logProbs[ngram] = math.Log(float64(count)+langSmoothing) - denominator
+	}
+	return profile
+}
+
+// extractNgrams returns the 1 to langNgramMax-grams of the words of a text, padded with spaces.
+func extractNgrams(text string) []string {
+
+	var ngrams []string
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	for _, word := range words {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= langNgramMax; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				if n == 1 && runes[i] == ' ' {
+					continue
+				}
+				ngrams = append(ngrams, string(runes[i:i+n]))
+			}
+		}
+	}
+
+	return ngrams
+}
+
+// DetectLanguage guesses the language of a query. The Accept-Language header of the request is used
+// as a prior: it weighs more on short queries and decides alone when the query has no letters at all.
+func DetectLanguage(q string, acceptLanguage string) LangGuess {
+
+	prior := getLanguagePrior(acceptLanguage)
+
+	// Only the words we will search for are meaningful, not the site: or inurl: values.
+	text := strings.Join(query.Highlights(query.Parse(q)), " ")
+
+	if lang, confidence := detectScript(text, prior); lang != "" {
+		return LangGuess{Lang: lang, Confidence: confidence}
+	}
+
+	ngrams := extractNgrams(text)
+
+	scores := make(map[string]float64)
+	if len(ngrams) == 0 || len(langProfiles) == 0 {
+		for _, lang := range SearchLanguages {
+			scores[lang] = math.Log(prior[lang])
+		}
+	} else {
+		for lang, profile := range langProfiles {
+			score := math.Log(prior[lang])
+			for _, ngram := range ngrams {
+				if logProb, ok := profile.logProbs[ngram]; ok {
+					score += langNgramWeight * logProb
+				} else {
+					score += langNgramWeight * profile.unknown
+				}
+			}
+			scores[lang] = score
+		}
+	}
+
+	return bestLanguage(scores)
+}
+
+// bestLanguage returns the language with the highest log-score, and its share of the total probability.
+// It falls back to defaultLang, with no confidence, when no score is finite.
+func bestLanguage(scores map[string]float64) LangGuess {
+
+	guess := LangGuess{}
+	best := math.Inf(-1)
+	for lang, score := range scores {
+		if score > best || (score == best && lang < guess.Lang) {
+			guess.Lang, best = lang, score
+		}
+	}
+
+	// No language has a usable score.
+	if guess.Lang == "" {
+		return LangGuess{Lang: defaultLang}
+	}
+
+	total := 0.0
+	for _, score := range scores {
+		total += math.Exp(score - best)
+	}
+	guess.Confidence = math.Floor(100/total) / 100
+
+	return guess
+}
+
+// detectScript recognizes the languages with their own script. It returns an empty string
+// if most letters of the text are in the Latin script.
+func detectScript(text string, prior map[string]float64) (string, float64) {
+
+	var letters, arabic, cyrillic, hangul, kana, han int
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		}
+	}
+
+	if letters == 0 {
+		return "", 0
+	}
+
+	var lang string
+	var count int
+
+	switch {
+	case arabic*2 > letters:
+		lang, count = "ar", arabic
+	case cyrillic*2 > letters:
+		lang, count = "ru", cyrillic
+	case hangul*2 > letters:
+		lang, count = "ko", hangul
+	// Japanese mixes kana and kanji. Kanji alone are usually Chinese, unless the browser says otherwise.
+	case kana > 0 && (kana+han)*2 > letters:
+		lang, count = "ja", kana+han
+	case han*2 > letters:
+		lang, count = "zh", han
+		if prior["ja"] > prior["zh"] {
+			lang = "ja"
+		}
+	default:
+		return "", 0
+	}
+
+	return lang, math.Floor(100*float64(count)/float64(letters)) / 100
+}
+
+// getLanguagePrior returns the prior probability of each search language, from an Accept-Language header.
+func getLanguagePrior(acceptLanguage string) map[string]float64 {
+
+	prior := make(map[string]float64, len(SearchLanguages))
+	for _, lang := range SearchLanguages {
+		prior[lang] = 1
+	}
+
+	accepted := ParseAcceptLanguage(acceptLanguage)
+	if len(accepted) == 0 {
+		prior[defaultLang]++
+	}
+	for _, a := range accepted {
+		if _, ok := prior[a.Lang]; ok {
+			prior[a.Lang] += acceptLanguageWeight * a.Quality
+		}
+	}
+
+	total := 0.0
+	for _, p := range prior {
+		total += p
+	}
+	for lang := range prior {
+		prior[lang] /= total
+	}
+
+	return prior
+}
+
+// AcceptedLanguage is a language of an Accept-Language header, with its quality value.
+type AcceptedLanguage struct {
+	Lang    string
+	Quality float64
+}
+
+// ParseAcceptLanguage parses an Accept-Language header like "fr-CH, fr;q=0.9, en;q=0.8".
+// Only primary language tags are kept, in decreasing order of quality.
+func ParseAcceptLanguage(header string) []AcceptedLanguage {
+
+	var accepted []AcceptedLanguage
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(header, ",") {
+
+		params := strings.Split(part, ";")
+
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+		if tag == "" || tag == "*" || seen[tag] {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+
+				// Invalid values, including NaN and infinities, drop the language.
+				if err != nil || !(q >= 0 && q <= 1) {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		seen[tag] = true
+		accepted = append(accepted, AcceptedLanguage{Lang: tag, Quality: quality})
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].Quality > accepted[j].Quality
+	})
+
+	return accepted
+}
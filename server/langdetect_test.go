@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q, acceptLanguage, lang string
+	}{
+		{"how to cook rice", "", "en"},
+		{"comment faire cuire du riz", "", "fr"},
+		{"wie kocht man reis", "", "de"},
+		{"cómo cocinar arroz", "", "es"},
+		{"come cucinare il riso", "", "it"},
+		{"hoe kook je rijst", "", "nl"},
+		{"como cozinhar arroz", "pt-BR", "pt"},
+		{"jak ugotować ryż", "", "pl"},
+		{"cách nấu cơm", "", "vi"},
+		{"как варить рис", "", "ru"},
+		{"كيفية طبخ الأرز", "", "ar"},
+		{"ご飯の炊き方", "", "ja"},
+		{"밥 짓는 법", "", "ko"},
+		{"如何煮米饭", "", "zh"},
+		{"東京", "ja,en;q=0.5", "ja"},
+		{"2016", "", "en"},
+		{"2016", "de-DE,de;q=0.9", "de"},
+		{"site:example.fr", "fr", "fr"},
+	}
+
+	for _, test := range tests {
+		if guess := DetectLanguage(test.q, test.acceptLanguage); guess.Lang != test.lang {
+			t.Errorf("DetectLanguage(%q, %q) = %#v, want %q", test.q, test.acceptLanguage, guess, test.lang)
+		}
+	}
+}
+
+func TestDetectShortQueries(t *testing.T) {
+	t.Parallel()
+
+	// Words shared by several languages follow the browser, other short queries still stand out.
+	tests := []struct {
+		q, acceptLanguage, lang string
+	}{
+		{"la casa", "it-IT,it;q=0.9", "it"},
+		{"la casa", "es", "es"},
+		{"tempo", "it", "it"},
+		{"tempo", "pt-BR", "pt"},
+		{"chat", "fr-FR,fr", "fr"},
+		{"chat", "", "en"},
+		{"auto", "de", "de"},
+		{"paris", "fr", "fr"},
+		{"de", "nl", "nl"},
+		{"le monde", "", "fr"},
+		{"der spiegel", "", "de"},
+		{"el tiempo", "", "es"},
+		{"il meteo", "", "it"},
+		{"het weer", "", "nl"},
+		{"the weather", "", "en"},
+		{"o que é", "", "pt"},
+		{"ryż", "", "pl"},
+		{"phở", "", "vi"},
+	}
+
+	for _, test := range tests {
+		if guess := DetectLanguage(test.q, test.acceptLanguage); guess.Lang != test.lang {
+			t.Errorf("DetectLanguage(%q, %q) = %#v, want %q", test.q, test.acceptLanguage, guess, test.lang)
+		}
+	}
+}
+
+func TestBestLanguageFallback(t *testing.T) {
+	t.Parallel()
+
+	if guess := bestLanguage(map[string]float64{}); guess != (LangGuess{Lang: "en"}) {
+		t.Fatalf("No scores should fall back to English: %#v", guess)
+	}
+
+	if guess := bestLanguage(map[string]float64{"fr": math.NaN(), "de": math.Inf(-1)}); guess != (LangGuess{Lang: "en"}) {
+		t.Fatalf("Invalid scores should fall back to English: %#v", guess)
+	}
+}
+
+func TestDetectLanguageConfidence(t *testing.T) {
+	t.Parallel()
+
+	long := DetectLanguage("quel temps fait-il aujourd'hui à paris", "")
+	short := DetectLanguage("paris", "")
+	none := DetectLanguage("42", "")
+
+	if long.Lang != "fr" || long.Confidence < 0.9 {
+		t.Fatalf("Long query should be confidently detected: %#v", long)
+	}
+	if short.Confidence >= long.Confidence || none.Confidence >= short.Confidence {
+		t.Fatalf("Confidence should decrease with less evidence: %#v %#v %#v", long, short, none)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	t.Parallel()
+
+	got := ParseAcceptLanguage("en;q=0.5, fr-CH, FR;q=0.9, *;q=0.1, de;q=0, es_ES;q=x")
+	want := []AcceptedLanguage{{"fr", 1}, {"en", 0.5}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseAcceptLanguage = %#v", got)
+	}
+
+	got = ParseAcceptLanguage("fr;q=NaN, de;q=Inf, es;q=2, it;q=-1, pt;q=-Inf, nl;q=1.0, en;q=0.3")
+	want = []AcceptedLanguage{{"nl", 1}, {"en", 0.3}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Out of range qualities should be dropped: %#v", got)
+	}
+
+	if ParseAcceptLanguage("") != nil {
+		t.Fatal("Empty header should have no languages")
+	}
+}
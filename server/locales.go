@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Locale is a message catalog for the interface, loaded from locales/[lang].json
+type Locale struct {
+	Lang string `json:"-"`
+
+	// Name is the name of the language, in that language.
+	Name string `json:"name"`
+
+	// Dir is the direction of the text: "ltr" or "rtl".
+	Dir string `json:"dir"`
+
+	// ThousandsSeparator groups the digits of numbers.
+	ThousandsSeparator string `json:"thousands"`
+
+	// Messages are format strings, with %s placeholders. They may contain HTML.
+	Messages map[string]string `json:"messages"`
+}
+
+// Locales contains all the message catalogs, by language.
+var Locales = make(map[string]*Locale)
+
+// LoadLocales loads all the message catalogs at startup. Messages missing
+// from a catalog are taken from the default language.
+func LoadLocales() {
+
+	files, err := filepath.Glob(path.Join(Config.PathFront, "locales/*.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, file := range files {
+		cnt, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		locale := &Locale{Dir: "ltr", ThousandsSeparator: ","}
+		if err := json.Unmarshal(cnt, locale); err != nil {
+			log.Fatalf("%s: %s", file, err)
+		}
+		locale.Lang = strings.TrimSuffix(path.Base(file), ".json")
+
+		Locales[locale.Lang] = locale
+	}
+
+	fallback := Locales[defaultLang]
+	if fallback == nil {
+		log.Fatalf("Missing locale for the default language %s", defaultLang)
+	}
+
+	for _, locale := range Locales {
+		for key, message := range fallback.Messages {
+			if _, ok := locale.Messages[key]; !ok {
+				locale.Messages[key] = message
+			}
+		}
+	}
+}
+
+// GetLocale negotiates the locale of the interface. The search language picked by the user
+// comes first, then the languages of the browser. Guessed search languages are ignored.
+func GetLocale(r *http.Request, search *SearchRequest) *Locale {
+
+	if search.Detected == nil {
+		if locale, ok := Locales[search.Lang]; ok {
+			return locale
+		}
+	}
+
+	for _, accepted := range ParseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if locale, ok := Locales[accepted.Lang]; ok {
+			return locale
+		}
+	}
+
+	return Locales[defaultLang]
+}
+
+// T translates a message. Arguments are HTML-escaped, and numbers are formatted for the locale.
+// Unknown messages are returned as their key, to be easily spotted.
+func (l *Locale) T(key string, args ...interface{}) string {
+
+	message, ok := l.Messages[key]
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+
+	formatted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case int:
+			formatted[i] = l.FormatNumber(int64(arg))
+		case int64:
+			formatted[i] = l.FormatNumber(arg)
+		case uint32:
+			formatted[i] = l.FormatNumber(int64(arg))
+		case string:
+			formatted[i] = template.HTMLEscapeString(arg)
+		default:
+			formatted[i] = template.HTMLEscapeString(fmt.Sprint(arg))
+		}
+	}
+
+	return fmt.Sprintf(message, formatted...)
+}
+
+// FormatNumber formats an integer with the thousands separator of the locale.
+func (l *Locale) FormatNumber(n int64) string {
+
+	digits := strconv.FormatInt(n, 10)
+
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var groups []string
+	for len(digits) > 3 {
+		groups = append([]string{digits[len(digits)-3:]}, groups...)
+		digits = digits[:len(digits)-3]
+	}
+	groups = append([]string{digits}, groups...)
+
+	return sign + strings.Join(groups, l.ThousandsSeparator)
+}
+
+// translate is the T template function: {{ T .Locale "key" args... }}
+func translate(l *Locale, key string, args ...interface{}) string {
+	return l.T(key, args...)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFormatNumber(t *testing.T) {
+	t.Parallel()
+
+	locale := &Locale{ThousandsSeparator: "."}
+
+	tests := map[int64]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1.000",
+		-1234567: "-1.234.567",
+	}
+
+	for n, want := range tests {
+		if got := locale.FormatNumber(n); got != want {
+			t.Errorf("FormatNumber(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+
+	fr := Locales["fr"]
+
+	if got := fr.T("results", int64(12345)); got != "Environ 12 345 résultats" {
+		t.Fatalf("T = %q", got)
+	}
+
+	if got := fr.T("more_from", "<b>"); got != "Plus de résultats de &lt;b&gt; &raquo;" {
+		t.Fatalf("Arguments should be escaped: %q", got)
+	}
+
+	if fr.T("xxxunknown") != "xxxunknown" {
+		t.Fatal("Unknown messages should be returned as their key")
+	}
+}
+
+func TestLocaleFiles(t *testing.T) {
+	t.Parallel()
+
+	// LoadLocales fills in missing messages, so the files are read again.
+	read := func(lang string) *Locale {
+		cnt, err := ioutil.ReadFile(path.Join(Config.PathFront, "locales", lang+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var locale Locale
+		if err := json.Unmarshal(cnt, &locale); err != nil {
+			t.Fatalf("%s: %s", lang, err)
+		}
+		return &locale
+	}
+
+	en := read(defaultLang)
+
+	// The interface is translated in all the search languages
+	for _, lang := range SearchLanguages {
+		locale := read(lang)
+		for key, message := range en.Messages {
+			translated, ok := locale.Messages[key]
+			if !ok {
+				t.Errorf("Locale %s is missing %s", lang, key)
+			} else if strings.Count(translated, "%s") != strings.Count(message, "%s") {
+				t.Errorf("Locale %s has wrong arguments in %s", lang, key)
+			}
+		}
+		for key := range locale.Messages {
+			if _, ok := en.Messages[key]; !ok {
+				t.Errorf("Locale %s has unknown message %s", lang, key)
+			}
+		}
+	}
+}
+
+func TestGetLocale(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		lang, acceptLanguage string
+		detected             bool
+		want                 string
+	}{
+		{"fr", "de", false, "fr"},
+		{"fr", "de", true, "de"},
+		{"", "ar-EG,en;q=0.5", false, "ar"},
+		{"ja", "xx, es;q=0.8", false, "ja"},
+		{"all", "xx, es;q=0.8", false, "es"},
+		{"all", "", false, "en"},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", test.acceptLanguage)
+
+		search := &SearchRequest{Lang: test.lang}
+		if test.detected {
+			search.Detected = &LangGuess{Lang: test.lang}
+		}
+
+		if got := GetLocale(r, search).Lang; got != test.want {
+			t.Errorf("GetLocale(%q, %q) = %q, want %q", test.lang, test.acceptLanguage, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 )
 
 // SetupGlobals performs global initialization tasks at startup.
@@ -10,17 +11,31 @@ func SetupGlobals() {
 
 	LoadConfig()
 	LoadBangs()
+	LoadRankingProfiles()
+	LoadExperiments()
+	LoadPopularQueries()
+	LoadLangProfiles()
+	LoadDictionary()
 	LoadTemplates()
+	LoadLocales()
 
 	ElasticsearchConnect()
 
 }
 
-// main is the entry point of the server.
+// main is the entry point of the server, and of the "eval" and "querylog-export" commands.
 func main() {
 
 	SetupGlobals()
 
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(EvalCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "querylog-export" {
+		os.Exit(QueryLogExportCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	router := CreateRouter()
 
 	log.Printf(
@@ -102,3 +102,345 @@ func BenchmarkHomepage(b *testing.B) {
 		}
 	}
 }
+
+func TestSearchOperatorsAreEchoed(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/api/search?g=en&q=xxxteststring+site%3Aexample.com")
+
+	if !strings.Contains(body, `"o":[{"f":"site","v":"example.com","r":"xxxteststring"}]`) {
+		t.Fatalf("Should echo operators! %s", body)
+	}
+
+	html := search(t, "/?g=en&q=xxxteststring+site%3Aexample.com")
+
+	if !strings.Contains(html, `<a class="op" href="/?g=en&q=xxxteststring"`) {
+		t.Fatal("Should display removable operators!")
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/?q=xxxteststring&p=100000")
+
+	if !strings.Contains(body, "no more pages") {
+		t.Fatal("Should display a no more pages message!")
+	}
+
+	if strings.Contains(body, "Next &raquo;") {
+		t.Fatal("Should not link to a next page!")
+	}
+
+	api := search(t, "/api/search?q=xxxteststring&p=100000")
+
+	if !strings.Contains(api, `"pl":true`) {
+		t.Fatal("Should tell API clients there are no more pages!")
+	}
+
+	if !strings.Contains(search(t, "/?q=xxxteststring&p=-3"), `data-page="1"`) {
+		t.Fatal("Negative pages should be the first one!")
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	t.Parallel()
+
+	resp, err := http.Get(server.URL + "/api/search?q=xxxteststring&cursor=garbage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Invalid cursors should be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestSafeSearch(t *testing.T) {
+	t.Parallel()
+
+	if !strings.Contains(search(t, "/api/search?q=xxxteststring"), `"safe":"moderate"`) {
+		t.Fatal("Should default to moderate!")
+	}
+
+	resp, err := http.Get(server.URL + "/?q=xxxteststring&safe=strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "safe" || cookies[0].Value != "strict" {
+		t.Fatal("Should remember the safe search level!")
+	}
+
+	req, err := http.NewRequest("GET", server.URL+"/api/search?q=xxxteststring", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookies[0])
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), `"safe":"strict"`) {
+		t.Fatal("Should use the safe search cookie!")
+	}
+
+	req, err = http.NewRequest("GET", server.URL+"/?q=xxxteststring&safe=strict", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookies[0])
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(resp.Cookies()) != 0 {
+		t.Fatal("Should only set the cookie when the level changes!")
+	}
+
+	req, err = http.NewRequest("GET", server.URL+"/?q=xxxteststring&safe=moderate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookies[0])
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if cookies = resp.Cookies(); len(cookies) != 1 || cookies[0].Name != "safe" || cookies[0].MaxAge != -1 {
+		t.Fatal("Should forget the default safe search level!")
+	}
+
+	resp, err = http.Get(server.URL + "/?q=xxxteststring&safe=moderate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(resp.Cookies()) != 0 {
+		t.Fatal("Should not set a cookie for the default safe search level!")
+	}
+}
+
+func TestLanguageDetection(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/api/search?q=xxxteststring+comment+faire+cuire+du+riz")
+
+	if !strings.Contains(body, `"g":"fr","dl":{"g":"fr","c":`) {
+		t.Fatalf("Should detect and echo the query language! %s", body)
+	}
+
+	body = search(t, "/api/search?g=de&q=xxxteststring+comment+faire+cuire+du+riz")
+
+	if !strings.Contains(body, `"g":"de"`) || strings.Contains(body, `"dl"`) {
+		t.Fatal("Should not override an explicit language!")
+	}
+
+	if strings.Contains(search(t, "/?g=%22%3E%3Cscript%3E&q=xxxteststring"), "<script>") {
+		t.Fatal("Should ignore invalid languages!")
+	}
+}
+
+func TestLocalizedInterface(t *testing.T) {
+	t.Parallel()
+
+	req, err := http.NewRequest("GET", server.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "ar,en;q=0.8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), `<html lang="ar" dir="rtl">`) {
+		t.Fatal("Should be displayed right-to-left in arabic!")
+	}
+
+	if !strings.Contains(search(t, "/?g=fr&q=xxxteststring&p=100000"), "Il n'y a plus de pages") {
+		t.Fatal("Should be displayed in french!")
+	}
+}
+
+func TestInstantAnswers(t *testing.T) {
+	t.Parallel()
+
+	if !strings.Contains(search(t, "/api/search?g=en&q=2%5E10+*+3"), `"a":{"t":"calculator","c":1,"d":{"i":"2^10 * 3","o":"3072"}`) {
+		t.Fatal("Should answer calculations in the API!")
+	}
+
+	if !strings.Contains(search(t, "/?g=en&q=5+miles+in+km"), `<span class="o">8.04672 km</span>`) {
+		t.Fatal("Should display conversions!")
+	}
+}
+
+func TestLuckyAndNavigational(t *testing.T) {
+
+	if !strings.Contains(search(t, "/api/search?g=en&q=%5Cxxxteststring"), `"r":"http://www.example.com/page/1"`) {
+		t.Fatal("Should redirect to the first hit!")
+	}
+
+	if !strings.Contains(search(t, "/api/search?g=en&q=%5Cxxxteststring"), `"rr":"lucky"`) {
+		t.Fatal("Should tell why we redirect!")
+	}
+
+	body := search(t, "/api/search?g=en&q=www.example.com/page/2")
+	if !strings.Contains(body, `"pn":{"i":"2"`) || strings.Contains(body, `"r":"`) {
+		t.Fatal("Should pin the page named by the query!")
+	}
+
+	if !strings.Contains(search(t, "/?g=en&q=www.example.com/page/2"), `class="r pn"`) {
+		t.Fatal("Should display the pinned page!")
+	}
+
+	body = search(t, "/api/search?g=en&q=Example.org:80/page%23top")
+	if !strings.Contains(body, `"gt":"https://example.org/page"`) || strings.Contains(body, `"pn"`) {
+		t.Fatal("Should offer going to a URL that isn't indexed!")
+	}
+
+	if !strings.Contains(search(t, "/?g=en&q=example.org"), `Go to <a href="https://example.org/">`) {
+		t.Fatal("Should display a link to a URL that isn't indexed!")
+	}
+
+	if strings.Contains(search(t, "/api/search?g=en&q=node.js"), `"gt":`) {
+		t.Fatal("File names are not URLs!")
+	}
+
+	defer func(v string) { Config.NavigationalMode = v }(Config.NavigationalMode)
+	Config.NavigationalMode = NavigationalRedirect
+
+	body = search(t, "/api/search?g=en&q=www.example.com/page/2")
+	if !strings.Contains(body, `"r":"http://www.example.com/page/2"`) || !strings.Contains(body, `"rr":"navigational"`) {
+		t.Fatal("Should redirect to the page named by the query!")
+	}
+}
+
+func TestExplain(t *testing.T) {
+
+	if strings.Contains(search(t, "/api/search?g=en&q=xxxteststring&explain=1"), `"explain":true`) {
+		t.Fatal("Explanations should be restricted!")
+	}
+
+	defer func(v bool) { Config.ExplainEnabled = v }(Config.ExplainEnabled)
+	Config.ExplainEnabled = true
+
+	if !strings.Contains(search(t, "/api/search?g=en&q=xxxteststring&explain=1"), `"explain":true`) {
+		t.Fatal("Explanations should be enabled!")
+	}
+
+	if !strings.Contains(search(t, "/?g=en&q=xxxteststring&explain=1"), `<input type="hidden" name="explain" value="1"/>`) {
+		t.Fatal("Explanations should be kept in the search form!")
+	}
+}
+
+func TestRankingProfiles(t *testing.T) {
+	t.Parallel()
+
+	if !strings.Contains(search(t, "/api/search?g=en&q=xxxteststring&profile=text"), `"profile":"text"`) {
+		t.Fatal("Should use the requested ranking profile!")
+	}
+
+	if strings.Contains(search(t, "/api/search?g=en&q=xxxteststring&profile=xxx"), `"profile"`) {
+		t.Fatal("Should ignore unknown ranking profiles!")
+	}
+}
+
+func TestExperimentEndpoints(t *testing.T) {
+	t.Parallel()
+
+	resp, err := http.Post(server.URL+"/api/click?e=xxx&i=xxx.a.xxx&n=1", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("Should reject invalid clicks!")
+	}
+
+	resp, err = http.Get(server.URL + "/admin/experiments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("Experiment results should be restricted to admins!")
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+
+	defer func(v string) { Config.AdminToken = v }(Config.AdminToken)
+	Config.AdminToken = "xxxtoken"
+
+	req, _ := http.NewRequest("GET", server.URL+"/admin/metrics", nil)
+	req.Header.Set("X-Admin-Token", "xxxtoken")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `"shadow": {"sampled":`) {
+		t.Fatalf("Should publish the shadow stats! %s", body)
+	}
+}
+
+func TestAdminReports(t *testing.T) {
+
+	defer func(v string) { Config.AdminToken = v }(Config.AdminToken)
+	defer func(v int) { Config.ReportWindow = v }(Config.ReportWindow)
+	Config.AdminToken = "xxxtoken"
+	Config.ReportWindow = 60
+
+	search(t, "/?q=xxxteststring&g=en")
+
+	for _, path := range []string{"/admin/reports", "/admin/reports?format=json"} {
+
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Reports should be for admins only, got %d", resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("X-Admin-Token", "xxxtoken")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 200 || !(strings.Contains(string(body), "<h2>Slow queries</h2>") || strings.Contains(string(body), `"langs":[{"g":"en","searches":`)) {
+			t.Fatalf("Wrong report %s", body)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Reasons for SearchResult.Redirect
+const (
+	RedirectBang         = "bang"
+	RedirectLucky        = "lucky"
+	RedirectNavigational = "navigational"
+)
+
+// Navigational modes, see Config.NavigationalMode
+const (
+	NavigationalOff      = "off"
+	NavigationalPin      = "pin"
+	NavigationalRedirect = "redirect"
+)
+
+// navigationalHostRegexp matches domain names, like "example.com".
+var navigationalHostRegexp = regexp.MustCompile(`^([a-z0-9-]+\.)+[a-z]{2,}$`)
+
+// navigationalTLDs are the top-level domains of hosts typed without a scheme: all the country codes
+// and the most used generic ones. "node.js" or "setup.exe" are more likely names than domains.
+var navigationalTLDs = make(map[string]bool)
+
+func init() {
+	for _, tld := range strings.Fields(`
+		ac ad ae af ag ai al am ao aq ar as at au aw ax az ba bb bd be bf bg bh bi bj bl bm bn bo bq br bs
+		bt bv bw by bz ca cc cd cf cg ch ci ck cl cm cn co cr cu cv cw cx cy cz de dj dk dm do dz ec ee eg
+		eh er es et eu fi fj fk fm fo fr ga gb gd ge gf gg gh gi gl gm gn gp gq gr gs gt gu gw gy hk hm hn
+		hr ht hu id ie il im in io iq ir is it je jm jo jp ke kg kh ki km kn kp kr kw ky kz la lb lc li lk
+		lr ls lt lu lv ly ma mc md me mf mg mh mk ml mm mn mo mp mq mr ms mt mu mv mw mx my mz na nc ne nf
+		ng ni nl no np nr nu nz om pa pe pf pg ph pk pl pm pn pr ps pt pw py qa re ro rs ru rw sa sb sc sd
+		se sg sh si sj sk sl sm sn so sr ss st sv sx sy sz tc td tf tg th tj tk tl tm tn to tr tt tv tw tz
+		ua ug uk um us uy uz va vc ve vg vi vn vu wf ws ye yt za zm zw
+		com net org edu gov mil int info biz name pro mobi app dev blog shop store online site tech xyz
+		club page wiki news art cloud design live museum travel jobs coop aero asia cat tel`) {
+		navigationalTLDs[tld] = true
+	}
+}
+
+// defaultPorts are removed from normalized URLs.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// IsLucky returns true if the query starts with Config.LuckyPrefix, to go straight to the first hit.
+func (req SearchRequest) IsLucky() bool {
+	return Config.LuckyPrefix != "" && strings.HasPrefix(req.Query, Config.LuckyPrefix) &&
+		strings.TrimSpace(strings.TrimPrefix(req.Query, Config.LuckyPrefix)) != ""
+}
+
+// WithoutLuckyPrefix returns the same search, without Config.LuckyPrefix.
+func (req SearchRequest) WithoutLuckyPrefix() SearchRequest {
+	other := req
+	other.Query = strings.TrimSpace(strings.TrimPrefix(req.Query, Config.LuckyPrefix))
+	return other
+}
+
+// NormalizeURL parses a query shaped like a domain name or a URL, like "Example.com" or
+// "https://example.com:443/page?utm_source=x#top". The host is lowercased, and the default port,
+// the fragment and the utm_* parameters are removed. Without a scheme, the top-level domain must be
+// in navigationalTLDs. It returns nil if the query isn't a URL, and whether a scheme was typed.
+func NormalizeURL(q string) (*url.URL, bool) {
+
+	q = strings.TrimSpace(q)
+	if q == "" || strings.ContainsAny(q, " \t\n") {
+		return nil, false
+	}
+
+	hasScheme := strings.Contains(q, "://")
+	if !hasScheme {
+		q = "http://" + q
+	}
+
+	u, err := url.Parse(q)
+	if err != nil || u.User != nil || u.Opaque != "" {
+		return nil, false
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return nil, false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if !navigationalHostRegexp.MatchString(host) {
+		return nil, false
+	}
+	if !hasScheme && !navigationalTLDs[host[strings.LastIndex(host, ".")+1:]] {
+		return nil, false
+	}
+
+	port := u.Port()
+	if port == "" || port == defaultPorts[u.Scheme] {
+		u.Host = host
+	} else {
+		u.Host = host + ":" + port
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+
+	// Parameters are kept in their original order, only tracking ones are removed.
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param != "" && !strings.HasPrefix(strings.ToLower(param), "utm_") {
+			params = append(params, param)
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+
+	return u, hasScheme
+}
+
+// GetNavigationalURLs returns the normalized URLs a domain or URL query may be referring to,
+// in order of likelihood. It returns nil if the query isn't shaped like a domain or a URL.
+func GetNavigationalURLs(q string) []string {
+
+	u, hasScheme := NormalizeURL(q)
+	if u == nil {
+		return nil
+	}
+
+	schemes := []string{"https", "http"}
+	if hasScheme {
+		schemes = []string{u.Scheme}
+	}
+
+	// "example.com" and "www.example.com" are usually the same site.
+	hosts := []string{u.Host}
+	if strings.HasPrefix(u.Host, "www.") {
+		hosts = append(hosts, strings.TrimPrefix(u.Host, "www."))
+	} else {
+		hosts = append(hosts, "www."+u.Host)
+	}
+
+	var urls []string
+	for _, host := range hosts {
+		for _, scheme := range schemes {
+			variant := *u
+			variant.Scheme = scheme
+			variant.Host = host
+			urls = append(urls, variant.String())
+		}
+	}
+
+	return urls
+}
+
+// IsRedirectURL returns true for the absolute http and https URLs we may redirect to.
+func IsRedirectURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isNavigational returns true if a request may be looked up as a URL.
+func (req SearchRequest) isNavigational() bool {
+	return Config.NavigationalMode != NavigationalOff && req.Page <= 1 && req.Cursor == ""
+}
+
+// GetGoToURL returns the normalized URL of a domain or URL query, to offer going there
+// directly when it isn't in the docs index.
+func (req SearchRequest) GetGoToURL() string {
+
+	if !req.isNavigational() {
+		return ""
+	}
+
+	urls := GetNavigationalURLs(req.Query)
+	if urls == nil {
+		return ""
+	}
+
+	return urls[0]
+}
+
+// BuildNavigationalRequest returns the Elasticsearch body looking for documents by URL.
+func BuildNavigationalRequest(urls []string) (string, error) {
+
+	jsonURLs, err := json.Marshal(urls)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`{
+      "query": {
+        "filtered": {
+          "filter": {
+            "terms": {
+              "%s": %s
+            }
+          }
+        }
+      },
+      "size": %d
+    }`, Config.DocsURLField, jsonURLs, len(urls)), nil
+}
+
+// navigationalResult is the outcome of FindNavigationalHit.
+type navigationalResult struct {
+	hit *Hit
+	err error
+}
+
+// StartNavigationalHit runs FindNavigationalHit concurrently, so that it doesn't delay the text
+// search. The channel receives the hit, or nil.
+func (req SearchRequest) StartNavigationalHit() <-chan navigationalResult {
+
+	found := make(chan navigationalResult, 1)
+
+	if !req.isNavigational() {
+		found <- navigationalResult{}
+		return found
+	}
+
+	go func() {
+		hit, err := req.FindNavigationalHit()
+		found <- navigationalResult{hit, err}
+	}()
+
+	return found
+}
+
+// FindNavigationalHit looks up a domain or URL query in the docs index. It returns nil
+// if the query isn't navigational, if the page isn't indexed or if safe search excludes it.
+func (req SearchRequest) FindNavigationalHit() (*Hit, error) {
+
+	if !req.isNavigational() {
+		return nil, nil
+	}
+
+	urls := GetNavigationalURLs(req.Query)
+	if urls == nil {
+		return nil, nil
+	}
+
+	if Config.TestData {
+		return findTestDataHit(req, urls), nil
+	}
+
+	body, err := BuildNavigationalRequest(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := req.getBackend()
+
+	result, _, err := ElasticsearchRequest(
+		backend.DocsClient,
+		"/"+backend.DocsIndex+"/page/_search?fields=title,summary,url",
+		body)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Hits == nil {
+		return nil, nil
+	}
+
+	// Prefer the URLs in their order of likelihood.
+	hitsByURL := make(map[string]*Hit)
+	for _, hit := range result.Hits.Hits {
+		hitsByURL[hit.Fields["url"].([]interface{})[0].(string)] = &Hit{
+			ID:      hit.Id,
+			URL:     hit.Fields["url"].([]interface{})[0].(string),
+			Title:   html.EscapeString(hit.Fields["title"].([]interface{})[0].(string)),
+			Summary: html.EscapeString(hit.Fields["summary"].([]interface{})[0].(string)),
+		}
+	}
+	for _, url := range urls {
+		if hit := hitsByURL[url]; hit != nil {
+			return req.filterUnsafeHit(hit)
+		}
+	}
+
+	return nil, nil
+}
+
+// filterUnsafeHit returns nil instead of a hit the safe search level wouldn't show.
+func (req SearchRequest) filterUnsafeHit(hit *Hit) (*Hit, error) {
+	if safe, err := req.IsSafeHit(hit.ID); err != nil || !safe {
+		return nil, err
+	}
+	return hit, nil
+}
+
+// findTestDataHit looks up URLs in the test data.
+func findTestDataHit(req SearchRequest, urls []string) *Hit {
+	for _, url := range urls {
+		for _, hit := range req.GenerateTestData().Hits {
+			if hit.URL == url {
+				return &hit
+			}
+		}
+	}
+	return nil
+}
+
+// PinHit moves a hit at the top of the results, so that it isn't displayed twice.
+func (page *SearchResult) PinHit(pinned *Hit) {
+
+	page.Pinned = pinned
+
+	hits := page.Hits[:0]
+	for _, hit := range page.Hits {
+		if hit.ID != pinned.ID {
+			hits = append(hits, hit)
+		}
+	}
+	page.Hits = hits
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetNavigationalURLs(t *testing.T) {
+	t.Parallel()
+
+	if GetNavigationalURLs("common search") != nil {
+		t.Fatal("Words are not navigational")
+	}
+
+	if GetNavigationalURLs("example") != nil {
+		t.Fatal("A single word is not a domain")
+	}
+
+	for _, q := range []string{"node.js", "setup.exe", "index.html", "v1.2"} {
+		if GetNavigationalURLs(q) != nil {
+			t.Fatalf("%s is not a domain", q)
+		}
+	}
+
+	for _, q := range []string{"socket.io", "bbc.co.uk", "wikipedia.org", "example.dev", "http://node.js"} {
+		if GetNavigationalURLs(q) == nil {
+			t.Fatalf("%s is a domain", q)
+		}
+	}
+
+	if !reflect.DeepEqual(GetNavigationalURLs("Example.com"), []string{
+		"https://example.com/", "http://example.com/", "https://www.example.com/", "http://www.example.com/"}) {
+		t.Fatal("Domains should be looked up with both schemes, with and without www")
+	}
+
+	if !reflect.DeepEqual(GetNavigationalURLs("https://www.example.com/page/1"), []string{
+		"https://www.example.com/page/1", "https://example.com/page/1"}) {
+		t.Fatal("URLs should be looked up with their scheme")
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	t.Parallel()
+
+	normalized := func(q string) string {
+		u, _ := NormalizeURL(q)
+		if u == nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	if normalized("HTTP://WWW.Example.COM:80") != "http://www.example.com/" {
+		t.Fatal("Should lowercase the scheme and host, and remove the default port")
+	}
+
+	if normalized("https://example.com:8443/Page") != "https://example.com:8443/Page" {
+		t.Fatal("Should keep other ports and the case of the path")
+	}
+
+	if normalized("example.com/a?utm_source=x&b=1&UTM_medium=y&c=2#top") != "http://example.com/a?b=1&c=2" {
+		t.Fatal("Should remove the fragment and tracking parameters")
+	}
+
+	if normalized("ftp://example.com/") != "" || normalized("user@example.com") != "" || normalized("example. com") != "" {
+		t.Fatal("Should not be URLs")
+	}
+
+	if _, hasScheme := NormalizeURL("https://example.com"); !hasScheme {
+		t.Fatal("Should have a scheme")
+	}
+}
+
+func TestIsRedirectURL(t *testing.T) {
+	t.Parallel()
+
+	for _, u := range []string{"http://example.com/", "https://example.com/page?q=1"} {
+		if !IsRedirectURL(u) {
+			t.Fatalf("Should redirect to %s", u)
+		}
+	}
+
+	for _, u := range []string{"javascript:alert(1)", "data:text/html,x", "ftp://example.com/", "//example.com/", "/page", "http:///page"} {
+		if IsRedirectURL(u) {
+			t.Fatalf("Should not redirect to %s", u)
+		}
+	}
+}
+
+func TestIsLucky(t *testing.T) {
+
+	defer func(v string) { Config.LuckyPrefix = v }(Config.LuckyPrefix)
+	Config.LuckyPrefix = "\\"
+
+	if !(SearchRequest{Query: "\\common search"}).IsLucky() {
+		t.Fatal("Should be lucky")
+	}
+
+	if (SearchRequest{Query: "\\ "}).IsLucky() {
+		t.Fatal("Should not be lucky without a query")
+	}
+
+	if (SearchRequest{Query: "\\common search"}).WithoutLuckyPrefix().Query != "common search" {
+		t.Fatal("Should remove the prefix")
+	}
+
+	Config.LuckyPrefix = ""
+	if (SearchRequest{Query: "\\common search"}).IsLucky() {
+		t.Fatal("Should be disabled")
+	}
+}
+
+func TestPinHit(t *testing.T) {
+	t.Parallel()
+
+	page := SearchRequest{}.GenerateTestData()
+	page.PinHit(&Hit{ID: "2", URL: "http://www.example.com/page/2"})
+
+	if page.Pinned == nil || page.Pinned.ID != "2" {
+		t.Fatal("Should be pinned")
+	}
+
+	if len(page.Hits) != 1 || page.Hits[0].ID != "1" {
+		t.Fatal("Should not be displayed twice")
+	}
+}
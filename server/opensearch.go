@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// validLangRegexp matches the values of the "g" parameter we accept in generated documents.
+var validLangRegexp = regexp.MustCompile(`^([a-z]{2}|all)$`)
+
+// openSearchDescription is an OpenSearch 1.1 description document.
+// See http://www.opensearch.org/Specifications/OpenSearch/1.1
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns         string          `xml:"xmlns,attr"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	Image         openSearchImage `xml:"Image"`
+	URLs          []openSearchURL `xml:"Url"`
+	Language      string          `xml:"Language,omitempty"`
+}
+
+type openSearchImage struct {
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Type   string `xml:"type,attr"`
+	URL    string `xml:",chardata"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// getPublicBaseURL returns the scheme and host clients use to reach us, like "https://example.com".
+func getPublicBaseURL(r *http.Request) string {
+
+	scheme := Config.PublicScheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+	}
+
+	host := Config.PublicHost
+	if host == "" {
+		host = r.Host
+	}
+
+	return scheme + "://" + host
+}
+
+// openSearchTemplate returns an OpenSearch URL template for a search, built like SearchRequest.Href().
+func openSearchTemplate(base string, lang string, path string) string {
+	href := SearchRequest{Lang: lang, Query: "__searchTerms__"}.Href()
+	href = strings.Replace(href, "__searchTerms__", "{searchTerms}", 1)
+	return base + path + strings.TrimPrefix(href, "/")
+}
+
+// BuildOpenSearchDescription returns the OpenSearch description of a base URL, in a language.
+func BuildOpenSearchDescription(base string, lang string) *openSearchDescription {
+
+	desc := &openSearchDescription{
+		Xmlns:         "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:     "Common Search",
+		Description:   "Search the Web with Common Search",
+		InputEncoding: "UTF-8",
+		Image: openSearchImage{
+			Width:  16,
+			Height: 16,
+			Type:   "image/x-icon",
+			URL:    base + "/favicon.ico",
+		},
+		URLs: []openSearchURL{
+			{
+				Type:     "text/html",
+				Method:   "get",
+				Template: openSearchTemplate(base, lang, "/"),
+			},
+			{
+				Type:     "application/x-suggestions+json",
+				Method:   "get",
+				Rel:      "suggestions",
+				Template: openSearchTemplate(base, lang, "/api/suggest") + "&format=opensearch",
+			},
+			{
+				Type:     "application/opensearchdescription+xml",
+				Method:   "get",
+				Rel:      "self",
+				Template: base + "/opensearch.xml" + strings.TrimPrefix(SearchRequest{Lang: lang}.Href(), "/"),
+			},
+		},
+	}
+
+	if lang != "" && lang != "all" {
+		desc.Language = lang
+	}
+
+	return desc
+}
+
+// OpenSearchHandler handles HTTP queries to our OpenSearch description (/opensearch.xml?g=*)
+func OpenSearchHandler(w http.ResponseWriter, r *http.Request) {
+
+	lang := r.FormValue("g")
+	if !validLangRegexp.MatchString(lang) {
+		lang = ""
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=UTF-8")
+
+	// Without a configured public URL, the document depends on request headers a shared cache could
+	// let any client set.
+	var vary []string
+	if Config.PublicHost == "" {
+		vary = append(vary, "Host")
+	}
+	if Config.PublicScheme == "" {
+		vary = append(vary, "X-Forwarded-Proto")
+	}
+	if len(vary) > 0 {
+		w.Header().Add("Vary", strings.Join(vary, ", "))
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	_, err := w.Write([]byte(xml.Header))
+	if err != nil {
+		return
+	}
+
+	err = xml.NewEncoder(w).Encode(BuildOpenSearchDescription(getPublicBaseURL(r), lang))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenSearchDescription(t *testing.T) {
+	t.Parallel()
+
+	body := search(t, "/opensearch.xml?g=fr")
+
+	for _, expected := range []string{
+		`<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">`,
+		`<Url type="text/html" method="get" template="` + server.URL + `/?g=fr&amp;q={searchTerms}"></Url>`,
+		`<Url type="application/x-suggestions+json" method="get" rel="suggestions" template="` + server.URL + `/api/suggest?g=fr&amp;q={searchTerms}&amp;format=opensearch"></Url>`,
+		`<Language>fr</Language>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %s in %s", expected, body)
+		}
+	}
+
+	if strings.Contains(search(t, "/opensearch.xml?g=%22%3E"), "g=&") {
+		t.Fatal("Invalid languages should be ignored")
+	}
+
+	if !strings.Contains(search(t, "/?g=fr"), `href="/opensearch.xml?g=fr"`) {
+		t.Fatal("Pages should link to the OpenSearch description")
+	}
+}
+
+func TestOpenSearchCaching(t *testing.T) {
+
+	headers := func() http.Header {
+		resp, err := http.Get(server.URL + "/opensearch.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header
+	}
+
+	h := headers()
+	if !strings.Contains(strings.Join(h["Vary"], ", "), "Host, X-Forwarded-Proto") || h.Get("Cache-Control") != "private, max-age=86400" {
+		t.Fatalf("Descriptions built from request headers shouldn't be shared: %v", h)
+	}
+
+	defer func(host, scheme string) {
+		Config.PublicHost = host
+		Config.PublicScheme = scheme
+	}(Config.PublicHost, Config.PublicScheme)
+	Config.PublicHost = "example.com"
+	Config.PublicScheme = "https"
+
+	h = headers()
+	if strings.Contains(strings.Join(h["Vary"], ", "), "Host") || h.Get("Cache-Control") != "public, max-age=86400" {
+		t.Fatalf("Descriptions with a public URL should be cacheable: %v", h)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/olivere/elastic.v3"
+	"time"
+)
+
+// CursorStart is the cursor value that starts a new cursor walk through all the results of a search.
+const CursorStart = "*"
+
+// ErrInvalidCursor is returned for cursors that can't be decoded, that belong to another search,
+// or whose scroll expired.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorSearch is everything in a search that changes which hits match and their order.
+type cursorSearch struct {
+	Query      string `json:"q"`
+	Lang       string `json:"g"`
+	SafeSearch string `json:"safe"`
+	Exact      bool   `json:"x,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+}
+
+// searchCursor is the decoded value of a cursor: the Elasticsearch scroll of the walk, with the
+// search it belongs to.
+type searchCursor struct {
+	cursorSearch
+	ScrollID string `json:"scroll"`
+}
+
+// IsPageLimitReached returns true if this page is beyond the ones we allow with offset paging.
+// Elasticsearch does more work for each page and refuses pages beyond its result window.
+func (req SearchRequest) IsPageLimitReached() bool {
+	return req.Cursor == "" && req.Page > Config.MaxPage
+}
+
+// getCursorSearch returns the parts of this search a cursor must match.
+func (req SearchRequest) getCursorSearch() cursorSearch {
+	return cursorSearch{
+		Query:      req.Query,
+		Lang:       req.Lang,
+		SafeSearch: req.SafeSearch,
+		Exact:      req.Exact,
+		Profile:    req.Profile,
+	}
+}
+
+// GetScrollID decodes the cursor of this search and returns the scroll to continue.
+// It returns "" for CursorStart, and ErrInvalidCursor if the cursor belongs to another search.
+func (req SearchRequest) GetScrollID() (string, error) {
+
+	if req.Cursor == "" || req.Cursor == CursorStart {
+		return "", nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ScrollID == "" {
+		return "", ErrInvalidCursor
+	}
+
+	if cursor.cursorSearch != req.getCursorSearch() {
+		return "", ErrInvalidCursor
+	}
+
+	return cursor.ScrollID, nil
+}
+
+// performTextRequest sends the text request for this search. With debug, the request body and
+// the Elasticsearch profile are kept there. With a cursor, results are walked with an Elasticsearch
+// scroll instead of offsets, so there is no limit on how far clients can go.
+func (req SearchRequest) performTextRequest(debug *SearchDebug) (*elastic.SearchResult, time.Duration, error) {
+
+	backend := req.getBackend()
+
+	scrollID, err := req.GetScrollID()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if scrollID != "" {
+
+		scrollBody, err := json.Marshal(map[string]string{
+			"scroll":    fmt.Sprintf("%ds", Config.CursorKeepAlive),
+			"scroll_id": scrollID,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		result, took, err := ElasticsearchRequest(backend.TextClient, "/_search/scroll", string(scrollBody))
+
+		// Scrolls are freed after Config.CursorKeepAlive seconds without requests.
+		if elastic.IsNotFound(err) {
+			return nil, took, ErrInvalidCursor
+		}
+		return result, took, err
+	}
+
+	textEsBody, err := req.BuildTextRequest()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	path := "/" + backend.TextIndex + "/page/_search"
+	if req.Cursor == CursorStart {
+		path += fmt.Sprintf("?scroll=%ds", Config.CursorKeepAlive)
+	}
+
+	if debug != nil {
+		debug.TextBody = textEsBody
+
+		profiled := new(profiledSearchResult)
+		took, err := ElasticsearchRequestInto(backend.TextClient, path, textEsBody, profiled)
+		if err != nil {
+			return nil, took, err
+		}
+
+		debug.Profile = profiled.Profile
+		return &profiled.SearchResult, took, nil
+	}
+
+	return ElasticsearchRequest(
+		backend.TextClient,
+		path,
+		textEsBody)
+}
+
+// getNextCursor returns the cursor to the next results after a text request, if there are any.
+// It stays valid for Config.CursorKeepAlive seconds, for the same search only.
+func (req SearchRequest) getNextCursor(textSearchResult *elastic.SearchResult) string {
+
+	if req.Cursor == "" || textSearchResult.ScrollId == "" || textSearchResult.Hits == nil ||
+		len(textSearchResult.Hits.Hits) < Config.ResultPageSize {
+		return ""
+	}
+
+	data, err := json.Marshal(searchCursor{req.getCursorSearch(), textSearchResult.ScrollId})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPopularQueryLength excludes long queries, which are more likely to be personal.
+const maxPopularQueryLength = 80
+
+// popularSaveInterval is the time between two saves of Config.PopularQueriesPath.
+const popularSaveInterval = time.Minute
+
+// PopularQuery counts the searches of a normalized query in a language.
+type PopularQuery struct {
+	Query string `json:"q"`
+
+	// Score and Recent are the counts of searches, decayed with Config.PopularHalfLife and
+	// Config.TrendingHalfLife hours as of Updated.
+	Score   float64   `json:"score"`
+	Recent  float64   `json:"recent"`
+	Updated time.Time `json:"updated"`
+
+	// sessions are the hashed sessions that searched a pending query, until there are
+	// Config.PopularMinSessions of them and it becomes public. They are never saved.
+	sessions map[string]bool
+}
+
+// TrendingQuery is a link to a trending query on the home page.
+type TrendingQuery struct {
+	Query string `json:"q"`
+	Href  string `json:"h"`
+}
+
+// popularQueries are the public queries of each language, and the pending ones that were searched by
+// too few sessions yet. They are pruned separately so that new queries never evict public ones, and
+// public ones never prevent new queries from reaching the threshold. words indexes the public queries
+// of each language by word, so that related queries don't scan all of them.
+var popularQueries = struct {
+	sync.Mutex
+	langs   map[string]map[string]*PopularQuery
+	pending map[string]map[string]*PopularQuery
+	words   map[string]map[string]map[string]*PopularQuery
+	dirty   bool
+}{
+	langs:   make(map[string]map[string]*PopularQuery),
+	pending: make(map[string]map[string]*PopularQuery),
+	words:   make(map[string]map[string]map[string]*PopularQuery),
+}
+
+// trustedProxies are the networks of Config.TrustedProxies.
+var trustedProxies []*net.IPNet
+
+// popularSessionSalt keys the hashes of sessions. It is never saved, so hashes can't be linked
+// to IP addresses after a restart.
+var popularSessionSalt []byte
+
+// decay returns a count decayed with a half-life in hours.
+func decay(count float64, elapsed time.Duration, halfLife int) float64 {
+	if halfLife <= 0 {
+		return count
+	}
+	return count * math.Exp2(-elapsed.Hours()/float64(halfLife))
+}
+
+// decayed returns the Score and Recent counts of a query at a time.
+func (popular *PopularQuery) decayed(now time.Time) (float64, float64) {
+	elapsed := now.Sub(popular.Updated)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return decay(popular.Score, elapsed, Config.PopularHalfLife), decay(popular.Recent, elapsed, Config.TrendingHalfLife)
+}
+
+// trend compares the recent rate of searches with the long-term one. It is positive for rising queries.
+func (popular *PopularQuery) trend(now time.Time) float64 {
+	score, recent := popular.decayed(now)
+	if Config.PopularHalfLife <= 0 || Config.TrendingHalfLife <= 0 {
+		return 0
+	}
+	return recent/float64(Config.TrendingHalfLife) - score/float64(Config.PopularHalfLife)
+}
+
+// LoadPopularQueries loads Config.PopularQueriesPath at startup, and saves it regularly from then on.
+func LoadPopularQueries() {
+
+	popularSessionSalt = make([]byte, 32)
+	if _, err := rand.Read(popularSessionSalt); err != nil {
+		log.Fatal(err)
+	}
+
+	proxies, err := parseTrustedProxies(Config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %s", err)
+	}
+	trustedProxies = proxies
+
+	if !Config.PopularQueries || Config.PopularQueriesPath == "" {
+		return
+	}
+
+	file := getPopularQueriesPath()
+
+	cnt, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if err == nil {
+		if err := parsePopularQueries(cnt); err != nil {
+			log.Fatalf("Invalid popular queries in %s: %s", file, err)
+		}
+	}
+
+	go func() {
+		for range time.Tick(popularSaveInterval) {
+			if err := SavePopularQueries(); err != nil {
+				log.Printf("Could not save popular queries: %s", err)
+			}
+		}
+	}()
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+
+	var proxies []*net.IPNet
+
+	for _, proxy := range strings.Split(list, ",") {
+
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// getPopularQueriesPath returns Config.PopularQueriesPath, relative to PathFront.
+func getPopularQueriesPath() string {
+	if path.IsAbs(Config.PopularQueriesPath) {
+		return Config.PopularQueriesPath
+	}
+	return path.Join(Config.PathFront, Config.PopularQueriesPath)
+}
+
+// parsePopularQueries replaces the popular queries with saved ones, as a list of queries by language.
+func parsePopularQueries(cnt []byte) error {
+
+	var saved map[string][]*PopularQuery
+	if err := json.Unmarshal(cnt, &saved); err != nil {
+		return err
+	}
+
+	langs := make(map[string]map[string]*PopularQuery)
+	for lang, queries := range saved {
+		langs[lang] = make(map[string]*PopularQuery)
+		for _, popular := range queries {
+			langs[lang][popular.Query] = popular
+		}
+	}
+
+	popularQueries.Lock()
+	popularQueries.langs = langs
+	popularQueries.pending = make(map[string]map[string]*PopularQuery)
+	popularQueries.words = make(map[string]map[string]map[string]*PopularQuery)
+	for lang, queries := range langs {
+		for _, popular := range queries {
+			indexPopularQuery(lang, popular)
+		}
+	}
+	popularQueries.dirty = false
+	popularQueries.Unlock()
+
+	return nil
+}
+
+// SavePopularQueries writes the public queries to Config.PopularQueriesPath, if they changed.
+func SavePopularQueries() error {
+
+	popularQueries.Lock()
+
+	if !popularQueries.dirty {
+		popularQueries.Unlock()
+		return nil
+	}
+
+	saved := make(map[string][]*PopularQuery)
+	for lang, queries := range popularQueries.langs {
+		for _, popular := range queries {
+			copied := *popular
+			saved[lang] = append(saved[lang], &copied)
+		}
+	}
+	popularQueries.dirty = false
+
+	popularQueries.Unlock()
+
+	cnt, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so that a crash never leaves half a file.
+	file := getPopularQueriesPath()
+	if err := ioutil.WriteFile(file+".tmp", cnt, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// getClientIP returns the IP address of the client of a request. X-Forwarded-For is only used
+// when the request comes from one of Config.TrustedProxies, which appends the address it saw last.
+func getClientIP(r *http.Request) net.IP {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if ip == nil || forwarded == "" {
+		return ip
+	}
+
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			parts := strings.Split(forwarded, ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+
+	return ip
+}
+
+// getPopularSession returns a hash identifying the network of the client of a request: its /24
+// for IPv4 and its /64 for IPv6, so that a single client can't pose as many sessions by changing
+// its address or its headers. It returns "" if the address is unknown.
+func getPopularSession(r *http.Request) string {
+
+	ip := getClientIP(r)
+	if ip == nil {
+		return ""
+	}
+
+	var network net.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		network = ip4.Mask(net.CIDRMask(24, 32))
+	} else {
+		network = ip.Mask(net.CIDRMask(64, 128))
+	}
+
+	mac := hmac.New(sha256.New, popularSessionSalt)
+	mac.Write(network)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// RecordPopularQuery counts a search in the popular queries, if Config.PopularQueries is enabled.
+// Only first pages of queries with hits are counted.
+func RecordPopularQuery(r *http.Request, req *SearchRequest, result *SearchResult) {
+
+	if !Config.PopularQueries || req.Page != 1 || req.Cursor != "" || result.RedirectReason == RedirectBang {
+		return
+	}
+
+	if (len(result.Hits) == 0 && result.Pinned == nil) || (result.Suggestion != nil && result.Suggestion.Corrected) {
+		return
+	}
+
+	query := NormalizeLoggedQuery(req.WithoutLuckyPrefix().Query)
+	if query == "" || len(query) > maxPopularQueryLength {
+		return
+	}
+
+	session := getPopularSession(r)
+	if session == "" {
+		return
+	}
+	now := time.Now()
+
+	popularQueries.Lock()
+	defer popularQueries.Unlock()
+
+	queries := popularQueries.langs[req.Lang]
+	if queries == nil {
+		queries = make(map[string]*PopularQuery)
+		popularQueries.langs[req.Lang] = queries
+	}
+	pending := popularQueries.pending[req.Lang]
+	if pending == nil {
+		pending = make(map[string]*PopularQuery)
+		popularQueries.pending[req.Lang] = pending
+	}
+
+	popular := queries[query]
+	if popular == nil {
+		popular = pending[query]
+	}
+	if popular == nil {
+		popular = &PopularQuery{Query: query, Updated: now, sessions: make(map[string]bool)}
+		pending[query] = popular
+	}
+
+	popular.Score, popular.Recent = popular.decayed(now)
+	popular.Score++
+	popular.Recent++
+	popular.Updated = now
+
+	if popular.sessions != nil {
+		popular.sessions[session] = true
+		if len(popular.sessions) < Config.PopularMinSessions {
+			if len(pending) > Config.PopularMaxQueries {
+				prunePendingQueries(pending)
+			}
+			return
+		}
+		popular.sessions = nil
+		delete(pending, query)
+		queries[query] = popular
+		indexPopularQuery(req.Lang, popular)
+	}
+
+	popularQueries.dirty = true
+
+	if len(queries) > Config.PopularMaxQueries {
+		prunePopularQueries(req.Lang, queries, now)
+	}
+}
+
+// indexPopularQuery adds a public query to the word index. popularQueries must be locked.
+func indexPopularQuery(lang string, popular *PopularQuery) {
+
+	words := popularQueries.words[lang]
+	if words == nil {
+		words = make(map[string]map[string]*PopularQuery)
+		popularQueries.words[lang] = words
+	}
+
+	for _, word := range strings.Fields(popular.Query) {
+		if words[word] == nil {
+			words[word] = make(map[string]*PopularQuery)
+		}
+		words[word][popular.Query] = popular
+	}
+}
+
+// unindexPopularQuery removes a public query from the word index. popularQueries must be locked.
+func unindexPopularQuery(lang string, query string) {
+
+	words := popularQueries.words[lang]
+
+	for _, word := range strings.Fields(query) {
+		delete(words[word], query)
+		if len(words[word]) == 0 {
+			delete(words, word)
+		}
+	}
+}
+
+// prunePopularQueries removes the tenth of the public queries of a language with the lowest scores.
+// popularQueries must be locked.
+func prunePopularQueries(lang string, queries map[string]*PopularQuery, now time.Time) {
+
+	scores := make(map[string]float64, len(queries))
+	names := make([]string, 0, len(queries))
+	for name, popular := range queries {
+		scores[name], _ = popular.decayed(now)
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return scores[names[i]] < scores[names[j]]
+	})
+
+	for _, name := range names[:len(names)/10+1] {
+		delete(queries, name)
+		unindexPopularQuery(lang, name)
+	}
+}
+
+// prunePendingQueries removes the tenth of the pending queries that were searched the longest time ago.
+// Queries that keep being searched stay until they reach Config.PopularMinSessions. popularQueries must
+// be locked.
+func prunePendingQueries(pending map[string]*PopularQuery) {
+
+	names := make([]string, 0, len(pending))
+	for name := range pending {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return pending[names[i]].Updated.Before(pending[names[j]].Updated)
+	})
+
+	for _, name := range names[:len(names)/10+1] {
+		delete(pending, name)
+	}
+}
+
+// getPublicQueries returns the public queries of a language accepted by keep, sorted by decreasing
+// rank and truncated to max. With words, only the queries with the least common of them are considered.
+func getPublicQueries(lang string, words []string, max int, keep func(*PopularQuery) bool, rank func(*PopularQuery) float64) []string {
+
+	popularQueries.Lock()
+
+	candidates := popularQueries.langs[lang]
+	for i, word := range words {
+		if withWord := popularQueries.words[lang][word]; i == 0 || len(withWord) < len(candidates) {
+			candidates = withWord
+		}
+	}
+
+	ranks := make(map[string]float64)
+	var names []string
+	for name, popular := range candidates {
+		if keep(popular) {
+			ranks[name] = rank(popular)
+			names = append(names, name)
+		}
+	}
+
+	popularQueries.Unlock()
+
+	sort.Slice(names, func(i, j int) bool {
+		if ranks[names[i]] != ranks[names[j]] {
+			return ranks[names[i]] > ranks[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) > max {
+		names = names[:max]
+	}
+	return names
+}
+
+// CompletePopularQuery returns the most popular queries starting with a partially typed query.
+func CompletePopularQuery(q string, lang string, max int) []string {
+
+	if !Config.PopularQueries {
+		return nil
+	}
+
+	prefix := NormalizeLoggedQuery(q)
+	if prefix == "" {
+		return nil
+	}
+
+	// "foo " completes to "foo bar" but not "foobar"
+	if strings.HasSuffix(q, " ") {
+		prefix += " "
+	}
+
+	now := time.Now()
+
+	return getPublicQueries(lang, nil, max, func(popular *PopularQuery) bool {
+		return strings.HasPrefix(popular.Query, prefix) && popular.Query != prefix
+	}, func(popular *PopularQuery) float64 {
+		score, _ := popular.decayed(now)
+		return score
+	})
+}
+
+// GetTrendingQueries returns the queries of a language with the most rising number of searches,
+// with links to search them. Their text isn't filtered, so there are none in strict safe search.
+func GetTrendingQueries(search SearchRequest, lang string, max int) []TrendingQuery {
+
+	if !Config.PopularQueries || max <= 0 || search.SafeSearch == SafeSearchStrict {
+		return nil
+	}
+
+	now := time.Now()
+
+	trend := func(popular *PopularQuery) float64 {
+		return popular.trend(now)
+	}
+	names := getPublicQueries(lang, nil, max, func(popular *PopularQuery) bool {
+		return trend(popular) > 0
+	}, trend)
+
+	var trending []TrendingQuery
+	for _, name := range names {
+		other := search.WithQuery(name)
+		other.Lang = lang
+		trending = append(trending, TrendingQuery{Query: name, Href: other.Href()})
+	}
+	return trending
+}
+
+// GetRelatedPopularQueries returns the most popular queries with all the words of a normalized query, and more.
+func GetRelatedPopularQueries(normalized string, lang string, max int) []string {
+
+	words := strings.Fields(normalized)
+	if !Config.PopularQueries || len(words) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	return getPublicQueries(lang, words, max, func(popular *PopularQuery) bool {
+		if popular.Query == normalized {
+			return false
+		}
+		fields := make(map[string]bool)
+		for _, field := range strings.Fields(popular.Query) {
+			fields[field] = true
+		}
+		for _, word := range words {
+			if !fields[word] {
+				return false
+			}
+		}
+		return true
+	}, func(popular *PopularQuery) float64 {
+		score, _ := popular.decayed(now)
+		return score
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetPopularQueries() {
+	popularQueries.Lock()
+	popularQueries.langs = make(map[string]map[string]*PopularQuery)
+	popularQueries.pending = make(map[string]map[string]*PopularQuery)
+	popularQueries.words = make(map[string]map[string]map[string]*PopularQuery)
+	popularQueries.dirty = false
+	popularQueries.Unlock()
+}
+
+func TestDecay(t *testing.T) {
+	t.Parallel()
+
+	if math.Abs(decay(4, 2*time.Hour, 1)-1) > 1e-9 || decay(4, 2*time.Hour, 0) != 4 {
+		t.Fatal("Wrong decay")
+	}
+}
+
+func TestPopularQueries(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(v bool) { Config.PopularQueries = v }(Config.PopularQueries)
+	defer func(v int) { Config.PopularMinSessions = v }(Config.PopularMinSessions)
+	defer func(v string) { Config.PopularQueriesPath = v }(Config.PopularQueriesPath)
+	defer resetPopularQueries()
+
+	Config.PopularQueries = true
+	Config.PopularMinSessions = 2
+	Config.PopularQueriesPath = path.Join(dir, "popular.json")
+	resetPopularQueries()
+
+	record := func(ip string, q string, page int, result *SearchResult) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		RecordPopularQuery(r, &SearchRequest{Query: q, Lang: "en", Page: page}, result)
+	}
+	hits := &SearchResult{Hits: []Hit{{ID: "1"}}}
+
+	record("10.0.1.1", "Foo  Bar", 1, hits)
+	record("10.0.1.1", "foo bar", 1, hits)
+	record("10.0.2.1", "foo bar", 2, hits)
+	record("10.0.2.1", "foo bar", 1, &SearchResult{})
+
+	if completions := CompletePopularQuery("foo", "en", 10); completions != nil {
+		t.Fatalf("Queries of a single session should stay private %v", completions)
+	}
+
+	record("10.0.2.1", "foo bar", 1, hits)
+	record("10.0.1.1", "foo baz", 1, hits)
+	record("10.0.2.1", "foo baz", 1, hits)
+	record("10.0.3.1", "foo baz", 1, hits)
+	record("10.0.3.1", "private", 1, hits)
+
+	if completions := CompletePopularQuery("Foo", "en", 10); !reflect.DeepEqual(completions, []string{"foo baz", "foo bar"}) {
+		t.Fatalf("Wrong completions %v", completions)
+	}
+	if completions := CompletePopularQuery("foo ", "en", 1); !reflect.DeepEqual(completions, []string{"foo baz"}) {
+		t.Fatalf("Wrong completions %v", completions)
+	}
+	if CompletePopularQuery("foob", "en", 10) != nil || CompletePopularQuery("foo", "fr", 10) != nil {
+		t.Fatal("Wrong completions")
+	}
+
+	if body := search(t, "/api/suggest?g=en&q=foo+b"); body != `{"q":"foo b","s":["foo baz","foo bar"]}`+"\n" {
+		t.Fatalf("Popular queries should be completed: %s", body)
+	}
+
+	trending := GetTrendingQueries(SearchRequest{SafeSearch: "off"}, "en", 1)
+	if len(trending) != 1 || trending[0] != (TrendingQuery{"foo baz", "/?g=en&q=foo+baz&safe=off"}) {
+		t.Fatalf("Wrong trending queries %v", trending)
+	}
+
+	if GetTrendingQueries(SearchRequest{SafeSearch: "strict"}, "en", 1) != nil {
+		t.Fatal("Strict safe search shouldn't display trending queries")
+	}
+
+	if body := search(t, "/api/suggest?g=en&q=foo+b&safe=strict"); body != `{"q":"foo b","s":[]}`+"\n" {
+		t.Fatalf("Strict safe search shouldn't complete popular queries: %s", body)
+	}
+
+	if body := search(t, "/?g=en"); !strings.Contains(body, `<div id="tr">`) || !strings.Contains(body, `>foo bar</a>`) {
+		t.Fatalf("Trending queries should be on the home page: %s", body)
+	}
+
+	// Only public queries are saved
+	if err := SavePopularQueries(); err != nil {
+		t.Fatal(err)
+	}
+	resetPopularQueries()
+
+	cnt, err := ioutil.ReadFile(Config.PopularQueriesPath)
+	if err != nil || strings.Contains(string(cnt), "private") {
+		t.Fatalf("Wrong saved queries %s %v", cnt, err)
+	}
+	if err := parsePopularQueries(cnt); err != nil {
+		t.Fatal(err)
+	}
+	if completions := CompletePopularQuery("foo", "en", 10); !reflect.DeepEqual(completions, []string{"foo baz", "foo bar"}) {
+		t.Fatalf("Wrong completions after loading %v", completions)
+	}
+}
+
+func TestPopularSessions(t *testing.T) {
+
+	defer func(v []*net.IPNet) { trustedProxies = v }(trustedProxies)
+
+	proxies, err := parseTrustedProxies("192.168.0.1, 10.1.0.0/16")
+	if err != nil || len(proxies) != 2 {
+		t.Fatalf("Wrong trusted proxies %v %v", proxies, err)
+	}
+	if _, err := parseTrustedProxies("xxx"); err == nil {
+		t.Fatal("Should reject invalid proxies")
+	}
+	trustedProxies = proxies
+
+	session := func(remoteAddr string, forwarded string, userAgent string) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return getPopularSession(r)
+	}
+
+	client := session("1.2.3.4:1234", "", "a")
+	if client == "" || session("1.2.3.200:5678", "", "b") != client || session("1.2.4.4:1234", "", "a") == client {
+		t.Fatal("Sessions should be /24 networks, whatever the user agent")
+	}
+	if session("[2001:db8::1]:1234", "", "a") != session("[2001:db8::ffff]:1234", "", "a") {
+		t.Fatal("Sessions should be /64 IPv6 networks")
+	}
+
+	if session("1.2.3.4:1234", "5.6.7.8", "a") != client {
+		t.Fatal("X-Forwarded-For should be ignored from untrusted clients")
+	}
+	if session("10.1.2.3:1234", "9.9.9.9, 1.2.3.4", "a") != client || session("192.168.0.1:1234", "1.2.3.4", "a") != client {
+		t.Fatal("X-Forwarded-For should be used from trusted proxies")
+	}
+	if session("10.1.2.3:1234", "xxx", "a") != "" {
+		t.Fatal("Invalid addresses have no session")
+	}
+}
+
+func TestPrunePopularQueries(t *testing.T) {
+
+	defer func(v bool) { Config.PopularQueries = v }(Config.PopularQueries)
+	defer func(v int) { Config.PopularMinSessions = v }(Config.PopularMinSessions)
+	defer func(v int) { Config.PopularMaxQueries = v }(Config.PopularMaxQueries)
+	defer resetPopularQueries()
+
+	Config.PopularQueries = true
+	Config.PopularMinSessions = 2
+	Config.PopularMaxQueries = 10
+	resetPopularQueries()
+
+	record := func(ip string, q string) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		RecordPopularQuery(r, &SearchRequest{Query: q, Lang: "en", Page: 1}, &SearchResult{Hits: []Hit{{ID: "1"}}})
+	}
+
+	record("10.0.1.1", "public")
+	record("10.0.2.1", "public")
+
+	// A flood of single-session queries evicts neither public queries nor pending ones still searched
+	record("10.0.1.1", "rising")
+	for i := 0; i < 50; i++ {
+		record("10.0.9.1", fmt.Sprintf("flood %d", i))
+		if i%5 == 0 {
+			record("10.0.9.1", "rising")
+		}
+	}
+	record("10.0.2.1", "rising")
+
+	if completions := CompletePopularQuery("", "en", 10); completions != nil {
+		t.Fatalf("Wrong completions %v", completions)
+	}
+	if !reflect.DeepEqual(CompletePopularQuery("publ", "en", 10), []string{"public"}) ||
+		!reflect.DeepEqual(CompletePopularQuery("ris", "en", 10), []string{"rising"}) {
+		t.Fatal("Pruning should keep public queries, and pending ones still being searched")
+	}
+
+	popularQueries.Lock()
+	pending := len(popularQueries.pending["en"])
+	popularQueries.Unlock()
+	if pending > Config.PopularMaxQueries+1 {
+		t.Fatalf("Pending queries should be pruned, %d left", pending)
+	}
+}
+
+func TestPopularWordIndex(t *testing.T) {
+
+	defer func(v bool) { Config.PopularQueries = v }(Config.PopularQueries)
+	defer resetPopularQueries()
+	Config.PopularQueries = true
+
+	now := time.Now()
+	if err := parsePopularQueries([]byte(fmt.Sprintf(`{"en": [
+	  {"q": "foo bar", "score": 3, "updated": %q},
+	  {"q": "bar foo baz", "score": 2, "updated": %q},
+	  {"q": "bar", "score": 1, "updated": %q}
+	]}`, now.Format(time.RFC3339), now.Format(time.RFC3339), now.Format(time.RFC3339)))); err != nil {
+		t.Fatal(err)
+	}
+
+	if related := GetRelatedPopularQueries("bar foo", "en", 10); !reflect.DeepEqual(related, []string{"foo bar", "bar foo baz"}) {
+		t.Fatalf("Wrong related queries %v", related)
+	}
+	if related := GetRelatedPopularQueries("foo unknown", "en", 10); related != nil {
+		t.Fatalf("Wrong related queries %v", related)
+	}
+
+	popularQueries.Lock()
+	prunePopularQueries("en", popularQueries.langs["en"], now)
+	_, indexed := popularQueries.words["en"]["bar"]["bar"]
+	popularQueries.Unlock()
+
+	if indexed {
+		t.Fatal("Pruned queries should leave the word index")
+	}
+	if related := GetRelatedPopularQueries("foo", "en", 10); !reflect.DeepEqual(related, []string{"foo bar", "bar foo baz"}) {
+		t.Fatalf("Wrong related queries after pruning %v", related)
+	}
+}
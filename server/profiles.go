@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RankingProfile defines which fields of the text index are searched and how hits are scored.
+// Profiles are loaded from Config.RankingProfiles and selected with profile=, or by language.
+type RankingProfile struct {
+	Name string `json:"-"`
+
+	// Fields are the searched fields with their boosts, like "title^3".
+	Fields []string `json:"fields"`
+
+	// MinimumShouldMatch is the share of the words that must match, like "-25%".
+	MinimumShouldMatch string `json:"minimum_should_match"`
+
+	// TieBreaker is the weight of the fields other than the best matching one, between 0 and 1.
+	TieBreaker float64 `json:"tie_breaker"`
+
+	// Functions multiply the text score of hits.
+	Functions []ScoringFunction `json:"functions"`
+}
+
+// ScoringFunction is a field_value_factor function of the text request. "{lang}" in Field is
+// replaced by the search language, and such functions are skipped when searching all languages.
+type ScoringFunction struct {
+	Field    string   `json:"field"`
+	Factor   float64  `json:"factor,omitempty"`
+	Modifier string   `json:"modifier,omitempty"`
+	Missing  *float64 `json:"missing,omitempty"`
+}
+
+// rankingProfileSet is the format of the Config.RankingProfiles file.
+type rankingProfileSet struct {
+
+	// Default is the name of the profile used when the language has none.
+	Default string `json:"default"`
+
+	// Languages are the default profiles of some search languages.
+	Languages map[string]string `json:"languages"`
+
+	Profiles map[string]*RankingProfile `json:"profiles"`
+}
+
+// rankingProfiles are the profiles loaded at startup.
+var rankingProfiles *rankingProfileSet
+
+// scoringModifiers are the modifiers supported by Elasticsearch in field_value_factor.
+var scoringModifiers = map[string]bool{
+	"": true, "none": true, "log": true, "log1p": true, "log2p": true, "ln": true,
+	"ln1p": true, "ln2p": true, "square": true, "sqrt": true, "reciprocal": true,
+}
+
+var minimumShouldMatchRegexp = regexp.MustCompile(`^-?[0-9]+%?$`)
+
+var scoringFieldRegexp = regexp.MustCompile(`^[a-z0-9_.]*(\{lang\})?[a-z0-9_.]*$`)
+
+// LoadRankingProfiles loads Config.RankingProfiles at startup. Invalid profiles are fatal.
+func LoadRankingProfiles() {
+
+	file := Config.RankingProfiles
+	if !path.IsAbs(file) {
+		file = path.Join(Config.PathFront, file)
+	}
+
+	cnt, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profiles, err := parseRankingProfiles(cnt)
+	if err != nil {
+		log.Fatalf("Invalid ranking profiles in %s: %s", file, err)
+	}
+
+	rankingProfiles = profiles
+}
+
+// parseRankingProfiles decodes and validates ranking profiles.
+func parseRankingProfiles(cnt []byte) (*rankingProfileSet, error) {
+
+	var profiles rankingProfileSet
+	if err := json.Unmarshal(cnt, &profiles); err != nil {
+		return nil, err
+	}
+
+	if len(profiles.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles")
+	}
+
+	// Sorted for deterministic errors
+	names := make([]string, 0, len(profiles.Profiles))
+	for name := range profiles.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := profiles.Profiles[name]
+		if profile == nil {
+			return nil, fmt.Errorf("profile %q is empty", name)
+		}
+		profile.Name = name
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %s", name, err)
+		}
+	}
+
+	if profiles.Profiles[profiles.Default] == nil {
+		return nil, fmt.Errorf("unknown default profile %q", profiles.Default)
+	}
+
+	for lang, name := range profiles.Languages {
+		if profiles.Profiles[name] == nil {
+			return nil, fmt.Errorf("unknown profile %q for language %q", name, lang)
+		}
+	}
+
+	return &profiles, nil
+}
+
+// validate checks that a profile will produce a valid Elasticsearch request.
+func (profile *RankingProfile) validate() error {
+
+	if len(profile.Fields) == 0 {
+		return fmt.Errorf("no fields")
+	}
+
+	for _, field := range profile.Fields {
+		parts := strings.SplitN(field, "^", 2)
+		if parts[0] == "" {
+			return fmt.Errorf("empty field name in %q", field)
+		}
+		if len(parts) == 2 {
+			if boost, err := strconv.ParseFloat(parts[1], 64); err != nil || boost <= 0 {
+				return fmt.Errorf("invalid boost in %q", field)
+			}
+		}
+	}
+
+	if !minimumShouldMatchRegexp.MatchString(profile.MinimumShouldMatch) {
+		return fmt.Errorf("invalid minimum_should_match %q", profile.MinimumShouldMatch)
+	}
+
+	if profile.TieBreaker < 0 || profile.TieBreaker > 1 {
+		return fmt.Errorf("tie_breaker %g is not between 0 and 1", profile.TieBreaker)
+	}
+
+	for _, function := range profile.Functions {
+		if function.Field == "" || !scoringFieldRegexp.MatchString(function.Field) {
+			return fmt.Errorf("invalid function field %q", function.Field)
+		}
+		if function.Factor < 0 {
+			return fmt.Errorf("negative factor for %q", function.Field)
+		}
+		if !scoringModifiers[function.Modifier] {
+			return fmt.Errorf("unknown modifier %q for %q", function.Modifier, function.Field)
+		}
+	}
+
+	return nil
+}
+
+// IsValidRankingProfile returns true if a profile= value names a loaded profile.
+func IsValidRankingProfile(name string) bool {
+	return rankingProfiles != nil && rankingProfiles.Profiles[name] != nil
+}
+
+// GetRankingProfile returns a profile by name, or else the default profile of a language.
+func GetRankingProfile(name string, lang string) *RankingProfile {
+
+	if profile := rankingProfiles.Profiles[name]; profile != nil {
+		return profile
+	}
+
+	if profile := rankingProfiles.Profiles[rankingProfiles.Languages[lang]]; profile != nil {
+		return profile
+	}
+
+	return rankingProfiles.Profiles[rankingProfiles.Default]
+}
+
+// GetRankingProfile returns the profile of a search request.
+func (req SearchRequest) GetRankingProfile() *RankingProfile {
+	return GetRankingProfile(req.Profile, req.Lang)
+}
+
+// getFunctionField returns the field of a scoring function for a language, and false if the
+// function is skipped in this language.
+func (function ScoringFunction) getFunctionField(lang string) (string, bool) {
+
+	if !strings.Contains(function.Field, "{lang}") {
+		return function.Field, true
+	}
+	if lang == "all" || lang == "" {
+		return "", false
+	}
+	return strings.Replace(function.Field, "{lang}", lang, -1), true
+}
+
+// GetScoringFunctionNames returns the names of the scoring functions of a profile for a language, in
+// the order of BuildScoringFunctions(). Functions are named after their field, like "lang_en".
+func (profile *RankingProfile) GetScoringFunctionNames(lang string) []string {
+
+	var names []string
+
+	for _, function := range profile.Functions {
+		if field, ok := function.getFunctionField(lang); ok {
+			names = append(names, field)
+		}
+	}
+
+	return names
+}
+
+// BuildScoringFunctions returns the JSON-encoded scoring functions of a profile for a language.
+func (profile *RankingProfile) BuildScoringFunctions(lang string) ([]string, error) {
+
+	var functions []string
+
+	for _, function := range profile.Functions {
+
+		field, ok := function.getFunctionField(lang)
+		if !ok {
+			continue
+		}
+
+		factor := esObject{"field": field}
+		if function.Factor != 0 {
+			factor["factor"] = function.Factor
+		}
+		if function.Modifier != "" {
+			factor["modifier"] = function.Modifier
+		}
+		if function.Missing != nil {
+			factor["missing"] = *function.Missing
+		}
+
+		jsonFunction, err := json.Marshal(esObject{"field_value_factor": factor})
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, string(jsonFunction))
+	}
+
+	return functions, nil
+}
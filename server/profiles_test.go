@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRankingProfiles(t *testing.T) {
+	t.Parallel()
+
+	valid := `{"default": "a", "languages": {"fr": "b"}, "profiles": {
+	  "a": {"fields": ["title^3", "body"], "minimum_should_match": "-25%", "tie_breaker": 0.5},
+	  "b": {"fields": ["body"], "minimum_should_match": "2", "tie_breaker": 0,
+	        "functions": [{"field": "lang_{lang}", "modifier": "log1p", "missing": 0.1}]}}}`
+
+	profiles, err := parseRankingProfiles([]byte(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles.Profiles["b"].Name != "b" {
+		t.Fatal("Profiles should be named")
+	}
+
+	for invalid, expected := range map[string]string{
+		`{"default": "a", "profiles": {}}`: "no profiles",
+		`{"default": "c", "profiles": {"a": {"fields": ["body"], "minimum_should_match": "1"}}}`:                           `unknown default profile "c"`,
+		`{"default": "a", "languages": {"fr": "c"}, "profiles": {"a": {"fields": ["body"], "minimum_should_match": "1"}}}`: `unknown profile "c" for language "fr"`,
+		`{"default": "a", "profiles": {"a": {"fields": [], "minimum_should_match": "1"}}}`:                                 `profile "a": no fields`,
+		`{"default": "a", "profiles": {"a": {"fields": ["title^x"], "minimum_should_match": "1"}}}`:                        `invalid boost in "title^x"`,
+		`{"default": "a", "profiles": {"a": {"fields": ["body"], "minimum_should_match": "most"}}}`:                        `invalid minimum_should_match "most"`,
+		`{"default": "a", "profiles": {"a": {"fields": ["body"], "minimum_should_match": "1", "tie_breaker": 2}}}`:         "tie_breaker 2 is not between 0 and 1",
+		`{"default": "a", "profiles": {"a": {"fields": ["body"], "minimum_should_match": "1",
+		  "functions": [{"field": "rank", "modifier": "cube"}]}}}`: `unknown modifier "cube" for "rank"`,
+	} {
+		if _, err := parseRankingProfiles([]byte(invalid)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestRankingProfileSelection(t *testing.T) {
+
+	defer func(v *rankingProfileSet) { rankingProfiles = v }(rankingProfiles)
+
+	rankingProfiles, _ = parseRankingProfiles([]byte(`{"default": "a", "languages": {"fr": "b"}, "profiles": {
+	  "a": {"fields": ["title^3", "body"], "minimum_should_match": "-25%", "tie_breaker": 0.5,
+	        "functions": [{"field": "rank", "factor": 1, "missing": 0}, {"field": "lang_{lang}", "missing": 0.002}]},
+	  "b": {"fields": ["body"], "minimum_should_match": "100%", "tie_breaker": 0.3}}}`))
+
+	if GetRankingProfile("", "en").Name != "a" || GetRankingProfile("", "fr").Name != "b" || GetRankingProfile("a", "fr").Name != "a" {
+		t.Fatal("Wrong profile selection")
+	}
+
+	body, _ := (SearchRequest{Query: "x", Lang: "en", Page: 1}).BuildTextRequest()
+	for _, expected := range []string{
+		`"fields":["title^3","body"]`,
+		`{"field_value_factor":{"factor":1,"field":"rank","missing":0}}`,
+		`{"field_value_factor":{"field":"lang_en","missing":0.002}}`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %s in %s", expected, body)
+		}
+	}
+
+	body, _ = (SearchRequest{Query: "x", Lang: "en", Page: 1, Profile: "b"}).BuildTextRequest()
+	if !strings.Contains(body, `"minimum_should_match":"100%"`) || strings.Contains(body, "field_value_factor") {
+		t.Fatalf("Should use the requested profile: %s", body)
+	}
+
+	body, _ = (SearchRequest{Query: "x", Lang: "all", Page: 1}).BuildTextRequest()
+	if strings.Contains(body, "lang_") {
+		t.Fatal("Language functions should be skipped for all languages")
+	}
+}
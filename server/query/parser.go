@@ -0,0 +1,206 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokWord tokenType = iota
+	tokPhrase
+	tokField
+	tokNot
+	tokOr
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	name string
+}
+
+// lex splits a query string into tokens.
+func lex(q string) []token {
+
+	var tokens []token
+	runes := []rune(q)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{typ: tokLParen})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{typ: tokRParen})
+			i++
+
+		case r == '|':
+			tokens = append(tokens, token{typ: tokOr})
+			i++
+
+		// A minus is only an exclusion at the start of a token: "e-mail" is a single word.
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, token{typ: tokNot})
+			i++
+
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokPhrase, text: string(runes[i+1 : end])})
+			i = end + 1
+
+		default:
+			end := i
+			for end < len(runes) && !isWordBoundary(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+
+			// Field restrictions like site:example.com or intitle:"a phrase"
+			if colon := strings.Index(word, ":"); colon > 0 && fieldNames[strings.ToLower(word[:colon])] {
+				name, value := strings.ToLower(word[:colon]), word[colon+1:]
+				if value == "" && end < len(runes) && runes[end] == '"' {
+					valueEnd := end + 1
+					for valueEnd < len(runes) && runes[valueEnd] != '"' {
+						valueEnd++
+					}
+					value = strings.Join(strings.Fields(string(runes[end+1:valueEnd])), " ")
+					end = valueEnd + 1
+				}
+				if value != "" {
+					tokens = append(tokens, token{typ: tokField, name: name, text: value})
+					i = end
+					continue
+				}
+			}
+
+			if word == "OR" {
+				tokens = append(tokens, token{typ: tokOr})
+			} else if word != "AND" {
+				tokens = append(tokens, token{typ: tokWord, text: word})
+			}
+			i = end
+		}
+	}
+
+	return tokens
+}
+
+// isWordBoundary returns true for the runes ending a word: "a|b" is "a | b".
+func isWordBoundary(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' || r == '|'
+}
+
+// parser is a recursive descent parser for this grammar:
+//
+//	sequence := or*
+//	or       := unary (("OR" | "|") unary)*
+//	unary    := "-" unary | primary
+//	primary  := "(" sequence ")" | PHRASE | FIELD | WORD
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+// parseSequence parses clauses until the end of the query or a closing parenthesis.
+func (p *parser) parseSequence(depth int) Node {
+	var nodes []Node
+	for tok := p.peek(); tok != nil; tok = p.peek() {
+		if tok.typ == tokRParen {
+			if depth > 0 {
+				break
+			}
+			// Stray closing parenthesis at the top level.
+			p.pos++
+			continue
+		}
+		nodes = append(nodes, p.parseOr(depth))
+	}
+	return newAnd(nodes)
+}
+
+func (p *parser) parseOr(depth int) Node {
+	nodes := []Node{p.parseUnary(depth)}
+	for tok := p.peek(); tok != nil && tok.typ == tokOr; tok = p.peek() {
+		p.pos++
+		next := p.peek()
+		if next == nil || next.typ == tokRParen || next.typ == tokOr {
+			continue
+		}
+		nodes = append(nodes, p.parseUnary(depth))
+	}
+	return newOr(nodes)
+}
+
+func (p *parser) parseUnary(depth int) Node {
+	tok := p.peek()
+	if tok != nil && tok.typ == tokNot {
+		p.pos++
+		if next := p.peek(); next == nil || next.typ == tokRParen || next.typ == tokOr {
+			return nil
+		}
+		return newNot(p.parseUnary(depth))
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *parser) parsePrimary(depth int) Node {
+	tok := p.peek()
+	if tok == nil {
+		return nil
+	}
+	p.pos++
+
+	switch tok.typ {
+	case tokLParen:
+		if depth >= maxDepth {
+			return nil
+		}
+		group := p.parseSequence(depth + 1)
+		if next := p.peek(); next != nil && next.typ == tokRParen {
+			p.pos++
+		}
+		return group
+
+	case tokPhrase:
+		words := strings.Fields(tok.text)
+		switch len(words) {
+		case 0:
+			return nil
+		case 1:
+			return Term{words[0]}
+		}
+		return Phrase{words}
+
+	case tokField:
+		return Field{Name: tok.name, Value: tok.text}
+
+	case tokWord:
+		return Term{tok.text}
+
+	case tokOr:
+		// A leading OR is just a word.
+		return Term{"OR"}
+	}
+
+	return nil
+}
@@ -0,0 +1,387 @@
+// Package query parses the search query language of Common Search into an AST.
+//
+// The supported syntax is deliberately small:
+//   - words are required terms, combined with an implicit AND
+//   - "quoted words" are phrases, matched as a whole
+//   - a leading minus excludes a term, a phrase or a group: -word, -"a phrase", -(a b)
+//   - OR (uppercase) or | between two clauses matches either of them
+//   - parentheses group clauses together
+//   - site:, intitle: and inurl: restrict a word or a quoted phrase to a field
+//
+// Parsing never fails: any user input yields a (possibly empty) tree, and
+// unbalanced quotes or parentheses are closed at the end of the query.
+package query
+
+import (
+	"strings"
+)
+
+// maxDepth is the maximum nesting of parentheses we follow. Deeper groups are flattened.
+const maxDepth = 16
+
+// Node is an element of a parsed query.
+type Node interface {
+
+	// String returns the node in query language syntax. Parsing it again yields the same tree.
+	String() string
+}
+
+// Term is a single word.
+type Term struct {
+	Text string
+}
+
+// Phrase is a sequence of words that must appear together.
+type Phrase struct {
+	Words []string
+}
+
+// Field restricts a value to a specific part of the documents, like site:example.com
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Field names supported by the query language.
+const (
+	FieldSite    = "site"
+	FieldInTitle = "intitle"
+	FieldInURL   = "inurl"
+)
+
+var fieldNames = map[string]bool{
+	FieldSite:    true,
+	FieldInTitle: true,
+	FieldInURL:   true,
+}
+
+// Not excludes documents matching its child.
+type Not struct {
+	Node Node
+}
+
+// And matches documents matching all its children.
+type And struct {
+	Nodes []Node
+}
+
+// Or matches documents matching at least one of its children.
+type Or struct {
+	Nodes []Node
+}
+
+func (t Term) String() string {
+	return t.Text
+}
+
+// Text returns the words of the phrase separated by single spaces.
+func (p Phrase) Text() string {
+	return strings.Join(p.Words, " ")
+}
+
+func (p Phrase) String() string {
+	return `"` + p.Text() + `"`
+}
+
+func (f Field) String() string {
+	if strings.ContainsAny(f.Value, " \t") {
+		return f.Name + `:"` + f.Value + `"`
+	}
+	return f.Name + ":" + f.Value
+}
+
+func (n Not) String() string {
+	return "-" + groupString(n.Node)
+}
+
+func (a And) String() string {
+	parts := make([]string, len(a.Nodes))
+	for i, node := range a.Nodes {
+		if _, isAnd := node.(And); isAnd {
+			parts[i] = groupString(node)
+		} else {
+			parts[i] = node.String()
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (o Or) String() string {
+	parts := make([]string, len(o.Nodes))
+	for i, node := range o.Nodes {
+		parts[i] = groupString(node)
+	}
+	return strings.Join(parts, " OR ")
+}
+
+// groupString wraps composite nodes in parentheses.
+func groupString(n Node) string {
+	switch n.(type) {
+	case And, Or:
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+// Parse parses a query string. Queries made only of operators, like "AND", are searched as words.
+// It returns nil for queries without any word.
+func Parse(q string) Node {
+	p := parser{tokens: lex(q)}
+	if n := p.parseSequence(0); n != nil {
+		return n
+	}
+
+	var words []Node
+	for _, word := range strings.FieldsFunc(q, isWordBoundary) {
+		words = append(words, Term{word})
+	}
+	return newAnd(words)
+}
+
+// Highlights returns the terms and phrases that documents will positively match,
+// in query order. Anything under a Not is left out.
+func Highlights(n Node) []string {
+	var highlights []string
+	Walk(n, func(node Node) bool {
+		switch node := node.(type) {
+		case Not:
+			return false
+		case Term:
+			highlights = append(highlights, node.Text)
+		case Phrase:
+			highlights = append(highlights, node.Text())
+		case Field:
+			if node.Name == FieldInTitle {
+				highlights = append(highlights, node.Value)
+			}
+		}
+		return true
+	})
+	return highlights
+}
+
+// Terms returns the words that documents will positively match, in query order.
+// Phrases, fields and anything under a Not are left out.
+func Terms(n Node) []string {
+	var terms []string
+	Walk(n, func(node Node) bool {
+		switch node := node.(type) {
+		case Not:
+			return false
+		case Term:
+			terms = append(terms, node.Text)
+		}
+		return true
+	})
+	return terms
+}
+
+// ReplaceTerms returns a copy of the query where the words returned by Terms are replaced
+// by the given ones, in order.
+func ReplaceTerms(n Node, words []string) Node {
+	i := 0
+	var replace func(n Node) Node
+	replace = func(n Node) Node {
+		switch n := n.(type) {
+		case Term:
+			if i < len(words) {
+				i++
+				return Term{words[i-1]}
+			}
+		case And:
+			nodes := make([]Node, len(n.Nodes))
+			for j, child := range n.Nodes {
+				nodes[j] = replace(child)
+			}
+			return newAnd(nodes)
+		case Or:
+			nodes := make([]Node, len(n.Nodes))
+			for j, child := range n.Nodes {
+				nodes[j] = replace(child)
+			}
+			return newOr(nodes)
+		}
+		return n
+	}
+	return replace(n)
+}
+
+// Fields returns all the field restrictions in a query, and whether each of them is excluded.
+func Fields(n Node) ([]Field, []bool) {
+	var fields []Field
+	var negated []bool
+	var walk func(n Node, isNegated bool)
+	walk = func(n Node, isNegated bool) {
+		Walk(n, func(node Node) bool {
+			switch node := node.(type) {
+			case Not:
+				walk(node.Node, !isNegated)
+				return false
+			case Field:
+				fields = append(fields, node)
+				negated = append(negated, isNegated)
+			}
+			return true
+		})
+	}
+	walk(n, false)
+	return fields, negated
+}
+
+// Remove returns a copy of the query without the target field restriction, or its exclusion.
+func Remove(n Node, target Field) Node {
+	switch n := n.(type) {
+	case Field:
+		if n == target {
+			return nil
+		}
+	case Not:
+		if n.Node == Node(target) {
+			return nil
+		}
+		return newNot(Remove(n.Node, target))
+	case And:
+		nodes := make([]Node, 0, len(n.Nodes))
+		for _, child := range n.Nodes {
+			nodes = append(nodes, Remove(child, target))
+		}
+		return newAnd(nodes)
+	case Or:
+		nodes := make([]Node, 0, len(n.Nodes))
+		for _, child := range n.Nodes {
+			nodes = append(nodes, Remove(child, target))
+		}
+		return newOr(nodes)
+	}
+	return n
+}
+
+// Count returns the number of terms in a query. Phrases and fields count as a single term.
+func Count(n Node) int {
+	count := 0
+	Walk(n, func(node Node) bool {
+		switch node.(type) {
+		case Term, Phrase, Field:
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Walk calls fn for n and its descendants, depth-first. Children are skipped if fn returns false.
+func Walk(n Node, fn func(Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	switch n := n.(type) {
+	case Not:
+		Walk(n.Node, fn)
+	case And:
+		for _, child := range n.Nodes {
+			Walk(child, fn)
+		}
+	case Or:
+		for _, child := range n.Nodes {
+			Walk(child, fn)
+		}
+	}
+}
+
+// Truncate keeps at most max terms of a query, in order. It returns the kept part
+// and the first clause that was dropped, or nil if nothing was dropped.
+func Truncate(n Node, max int) (Node, Node) {
+	kept, dropped, _ := truncate(n, max)
+	return kept, dropped
+}
+
+func truncate(n Node, budget int) (Node, Node, int) {
+	switch n := n.(type) {
+	case Term, Phrase, Field:
+		if budget < 1 {
+			return nil, n, 0
+		}
+		return n, nil, 1
+	case Not:
+		kept, dropped, used := truncate(n.Node, budget)
+		if kept != nil {
+			kept = Not{kept}
+		}
+		if dropped != nil {
+			dropped = Not{dropped}
+		}
+		return kept, dropped, used
+	case And:
+		kept, dropped, used := truncateChildren(n.Nodes, budget)
+		return newAnd(kept), dropped, used
+	case Or:
+		kept, dropped, used := truncateChildren(n.Nodes, budget)
+		return newOr(kept), dropped, used
+	}
+	return nil, nil, 0
+}
+
+func truncateChildren(nodes []Node, budget int) ([]Node, Node, int) {
+	var kept []Node
+	used := 0
+	for _, child := range nodes {
+		k, dropped, u := truncate(child, budget-used)
+		if k != nil {
+			kept = append(kept, k)
+		}
+		used += u
+		if dropped != nil {
+			return kept, dropped, used
+		}
+	}
+	return kept, nil, used
+}
+
+// newAnd builds an And node, flattening nested Ands and simplifying trivial cases.
+func newAnd(nodes []Node) Node {
+	var flat []Node
+	for _, node := range nodes {
+		if and, isAnd := node.(And); isAnd {
+			flat = append(flat, and.Nodes...)
+		} else if node != nil {
+			flat = append(flat, node)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	}
+	return And{flat}
+}
+
+// newOr builds an Or node, flattening nested Ors and simplifying trivial cases.
+func newOr(nodes []Node) Node {
+	var flat []Node
+	for _, node := range nodes {
+		if or, isOr := node.(Or); isOr {
+			flat = append(flat, or.Nodes...)
+		} else if node != nil {
+			flat = append(flat, node)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	}
+	return Or{flat}
+}
+
+// newNot builds a Not node, removing double negations.
+func newNot(n Node) Node {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case Not:
+		return n.Node
+	}
+	return Not{n}
+}
@@ -0,0 +1,168 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q    string
+		want Node
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"foo", Term{"foo"}},
+		{"foo  bar", And{[]Node{Term{"foo"}, Term{"bar"}}}},
+		{`"foo bar"`, Phrase{[]string{"foo", "bar"}}},
+		{`"foo"`, Term{"foo"}},
+		{`"foo bar`, Phrase{[]string{"foo", "bar"}}},
+		{"-foo bar", And{[]Node{Not{Term{"foo"}}, Term{"bar"}}}},
+		{"e-mail", Term{"e-mail"}},
+		{"a - b", And{[]Node{Term{"a"}, Term{"-"}, Term{"b"}}}},
+		{"--foo", Term{"foo"}},
+		{"foo OR bar", Or{[]Node{Term{"foo"}, Term{"bar"}}}},
+		{"foo | bar", Or{[]Node{Term{"foo"}, Term{"bar"}}}},
+		{"foo|bar", Or{[]Node{Term{"foo"}, Term{"bar"}}}},
+		{"(a|b)c", And{[]Node{Or{[]Node{Term{"a"}, Term{"b"}}}, Term{"c"}}}},
+		{"foo|", Term{"foo"}},
+		{"foo or bar", And{[]Node{Term{"foo"}, Term{"or"}, Term{"bar"}}}},
+		{"a AND b", And{[]Node{Term{"a"}, Term{"b"}}}},
+		{"a b OR c", And{[]Node{Term{"a"}, Or{[]Node{Term{"b"}, Term{"c"}}}}}},
+		{"(a b) OR c", Or{[]Node{And{[]Node{Term{"a"}, Term{"b"}}}, Term{"c"}}}},
+		{"-(a OR b) c", And{[]Node{Not{Or{[]Node{Term{"a"}, Term{"b"}}}}, Term{"c"}}}},
+		{`-"a b"`, Not{Phrase{[]string{"a", "b"}}}},
+		{"(a (b", And{[]Node{Term{"a"}, Term{"b"}}}},
+		{"a) b", And{[]Node{Term{"a"}, Term{"b"}}}},
+		{"OR", Term{"OR"}},
+		{"a OR", Term{"a"}},
+		{"a OR OR b", Or{[]Node{Term{"a"}, Term{"b"}}}},
+		{"-", Term{"-"}},
+		{"()", nil},
+		{`""`, nil},
+		{`( "" )`, nil},
+		{"AND", Term{"AND"}},
+		{"AND (AND)", And{[]Node{Term{"AND"}, Term{"AND"}}}},
+		{"-(", Term{"-"}},
+		{"site:example.com foo", And{[]Node{Field{"site", "example.com"}, Term{"foo"}}}},
+		{`InTitle:"a  b" -inurl:c`, And{[]Node{Field{"intitle", "a b"}, Not{Field{"inurl", "c"}}}}},
+		{`intitle:"a`, Field{"intitle", "a"}},
+		{"site: foo", And{[]Node{Term{"site:"}, Term{"foo"}}}},
+		{"intitle:", Term{"intitle:"}},
+		{"http://example.com", Term{"http://example.com"}},
+	}
+
+	for _, test := range tests {
+		if got := Parse(test.q); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", test.q, got, test.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	t.Parallel()
+
+	for _, q := range []string{
+		"foo",
+		`foo "bar baz" -qux`,
+		"a b OR c",
+		"(a b) OR c",
+		`-(a OR "b c") d`,
+		`site:example.com intitle:"a b" -inurl:c`,
+	} {
+		if got := Parse(q).String(); got != q {
+			t.Errorf("Parse(%q).String() = %q", q, got)
+		}
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	t.Parallel()
+
+	got := Highlights(Parse(`foo "bar baz" -qux (a OR -b) site:c intitle:d`))
+	want := []string{"foo", "bar baz", "a", "d"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Highlights = %#v, want %#v", got, want)
+	}
+}
+
+func TestCountAndTruncate(t *testing.T) {
+	t.Parallel()
+
+	n := Parse(`a "b c d" -e (f OR g) h`)
+
+	if Count(n) != 6 {
+		t.Fatalf("Count = %d", Count(n))
+	}
+
+	tests := []struct {
+		max           int
+		kept, dropped string
+	}{
+		{10, `a "b c d" -e f OR g h`, ""},
+		{6, `a "b c d" -e f OR g h`, ""},
+		{5, `a "b c d" -e f OR g`, "h"},
+		{4, `a "b c d" -e f`, "g"},
+		{2, `a "b c d"`, "-e"},
+		{0, "", "a"},
+	}
+
+	for _, test := range tests {
+		kept, dropped := Truncate(n, test.max)
+
+		keptString, droppedString := "", ""
+		if kept != nil {
+			keptString = kept.String()
+		}
+		if dropped != nil {
+			droppedString = dropped.String()
+		}
+
+		if keptString != test.kept || droppedString != test.dropped {
+			t.Errorf("Truncate(%d) = %q, %q", test.max, keptString, droppedString)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	t.Parallel()
+
+	n := Parse("a site:b -(inurl:c OR -intitle:d)")
+
+	fields, negated := Fields(n)
+	if !reflect.DeepEqual(fields, []Field{{"site", "b"}, {"inurl", "c"}, {"intitle", "d"}}) {
+		t.Fatalf("Fields = %#v", fields)
+	}
+	if !reflect.DeepEqual(negated, []bool{false, true, false}) {
+		t.Fatalf("Negated = %#v", negated)
+	}
+
+	if got := Remove(n, Field{"site", "b"}).String(); got != "a -(inurl:c OR -intitle:d)" {
+		t.Fatalf("Remove = %q", got)
+	}
+
+	if got := Remove(n, Field{"intitle", "d"}).String(); got != "a site:b -inurl:c" {
+		t.Fatalf("Remove = %q", got)
+	}
+
+	if Remove(Field{"site", "b"}, Field{"site", "b"}) != nil {
+		t.Fatal("Remove should return nil for empty queries")
+	}
+}
+
+func TestTerms(t *testing.T) {
+	t.Parallel()
+
+	n := Parse(`foo "bar baz" -qux (a OR -b) site:c`)
+
+	if got := Terms(n); !reflect.DeepEqual(got, []string{"foo", "a"}) {
+		t.Fatalf("Terms = %#v", got)
+	}
+
+	if got := ReplaceTerms(n, []string{"x", "y"}).String(); got != `x "bar baz" -qux y OR -b site:c` {
+		t.Fatalf("ReplaceTerms = %q", got)
+	}
+}
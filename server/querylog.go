@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryLogRecord is a line of the query log. It never contains anything identifying the user:
+// no IP, cookie, session or user agent, and times are truncated to the hour.
+type QueryLogRecord struct {
+	Time     time.Time          `json:"time"`
+	Query    string             `json:"q"`
+	Lang     string             `json:"g"`
+	Page     int                `json:"p"`
+	Hits     int64              `json:"c"`
+	Timing   SearchResultTiming `json:"t"`
+	Redirect string             `json:"rr,omitempty"`
+	Bang     string             `json:"bang,omitempty"`
+}
+
+// queryLogger appends records to Config.QueryLogPath and rotates it.
+type queryLogger struct {
+	sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+var queryLog queryLogger
+
+// NormalizeLoggedQuery makes identical queries comparable: lowercased, with single spaces.
+func NormalizeLoggedQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// LogQuery appends a search to the query log, if Config.QueryLogPath is set.
+func LogQuery(req *SearchRequest, result *SearchResult) {
+
+	if Config.QueryLogPath == "" {
+		return
+	}
+
+	record := QueryLogRecord{
+		Time:     time.Now().UTC().Truncate(time.Hour),
+		Query:    NormalizeLoggedQuery(req.WithoutLuckyPrefix().Query),
+		Lang:     req.Lang,
+		Page:     req.Page,
+		Hits:     result.TotalCount,
+		Timing:   result.Timing,
+		Redirect: result.RedirectReason,
+	}
+	if result.RedirectReason == RedirectBang {
+		record.Bang = getUsedBang(req.Query)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Query log: %s", err)
+		return
+	}
+
+	if err := queryLog.write(append(line, '\n')); err != nil {
+		log.Printf("Query log: %s", err)
+	}
+}
+
+// getUsedBang returns the name of the first known bang in a query.
+func getUsedBang(query string) string {
+	for _, part := range strings.Fields(query) {
+		if len(part) > 1 && strings.HasPrefix(part, "!") && bangs[part[1:]] != nil {
+			return part[1:]
+		}
+	}
+	return ""
+}
+
+// write appends a line to the log file, rotating it first if it is too big or too old.
+func (logger *queryLogger) write(line []byte) error {
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.file != nil && logger.needsRotation(int64(len(line))) {
+		if err := logger.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if logger.file == nil {
+		if err := logger.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := logger.file.Write(line)
+	logger.size += int64(n)
+	return err
+}
+
+func (logger *queryLogger) needsRotation(size int64) bool {
+	if Config.QueryLogMaxSize > 0 && logger.size+size > int64(Config.QueryLogMaxSize)*1024*1024 {
+		return true
+	}
+	return Config.QueryLogMaxAge > 0 && time.Since(logger.opened) > time.Duration(Config.QueryLogMaxAge)*time.Hour
+}
+
+func (logger *queryLogger) open() error {
+
+	file, err := os.OpenFile(Config.QueryLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	logger.file = file
+	logger.size = info.Size()
+	logger.opened = time.Now()
+	return nil
+}
+
+// rotate renames the current log file with a timestamp suffix. The next write opens a new one.
+func (logger *queryLogger) rotate() error {
+
+	if err := logger.file.Close(); err != nil {
+		return err
+	}
+	logger.file = nil
+
+	return os.Rename(Config.QueryLogPath, Config.QueryLogPath+"."+time.Now().UTC().Format("20060102T150405.000000000"))
+}
+
+// QueryLogExportCommand runs "cosr-front querylog-export [-k 5] files..." and returns the exit code
+// of the process. It writes the records of the query logs to stdout, with the text of queries seen
+// in fewer than k different hours replaced by an empty string. This is a frequency threshold, not
+// k-anonymity: records aren't linked to users, so a query one person searched in k different hours
+// is still exported. It only drops the rarest queries, which are the most likely to be personal.
+func QueryLogExportCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet("querylog-export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	k := flags.Int("k", 5, "minimum number of different hours a query must have been searched in to be exported (a frequency threshold, not per-user k-anonymity)")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *k < 1 {
+		fmt.Fprintln(stderr, "Usage: cosr-front querylog-export [flags] querylog.ndjson...")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	var records []QueryLogRecord
+	for _, name := range flags.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		fileRecords, err := ParseQueryLog(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", name, err)
+			return 2
+		}
+		records = append(records, fileRecords...)
+	}
+
+	suppressed := SuppressRareQueries(records, *k)
+
+	encoder := json.NewEncoder(stdout)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	fmt.Fprintf(stderr, "Exported %d records, %d with a suppressed query\n", len(records), suppressed)
+	return 0
+}
+
+// ParseQueryLog reads the records of a query log. Empty lines are ignored.
+func ParseQueryLog(reader io.Reader) ([]QueryLogRecord, error) {
+
+	var records []QueryLogRecord
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record QueryLogRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// SuppressRareQueries empties the queries, and their bangs, that were searched in fewer than k
+// different hours across all languages. Only first pages count, and each query counts once per hour,
+// so that paging or repeating a search within an hour doesn't make it common. It returns the number
+// of suppressed records.
+func SuppressRareQueries(records []QueryLogRecord, k int) int {
+
+	type searchKey struct {
+		query string
+		hour  time.Time
+	}
+
+	searches := make(map[searchKey]bool)
+	counts := make(map[string]int)
+	for _, record := range records {
+		key := searchKey{NormalizeLoggedQuery(record.Query), record.Time.Truncate(time.Hour)}
+		if record.Page > 1 || searches[key] {
+			continue
+		}
+		searches[key] = true
+		counts[key.query]++
+	}
+
+	suppressed := 0
+	for i := range records {
+		if counts[NormalizeLoggedQuery(records[i].Query)] < k {
+			records[i].Query = ""
+			records[i].Bang = ""
+			suppressed++
+		}
+	}
+	return suppressed
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuppressRareQueries(t *testing.T) {
+	t.Parallel()
+
+	hour := time.Date(2016, 5, 12, 10, 0, 0, 0, time.UTC)
+
+	records := []QueryLogRecord{
+		{Time: hour, Query: "foo", Lang: "en", Page: 1},
+		{Time: hour.Add(time.Hour), Query: "foo", Lang: "fr", Page: 1},
+		{Time: hour, Query: "rare", Lang: "en", Page: 1, Bang: "g"},
+		{Time: hour.Add(2 * time.Hour), Query: "Foo", Lang: "en", Page: 1},
+
+		// One user paging and repeating a search
+		{Time: hour, Query: "rare", Lang: "en", Page: 1},
+		{Time: hour, Query: "rare", Lang: "en", Page: 2},
+		{Time: hour.Add(time.Hour), Query: "rare", Lang: "en", Page: 2},
+		{Time: hour.Add(2 * time.Hour), Query: "rare", Lang: "en", Page: 3},
+	}
+
+	if n := SuppressRareQueries(records, 3); n != 5 {
+		t.Fatalf("Wrong number of suppressed records %d", n)
+	}
+	if records[0].Query != "foo" || records[1].Query != "foo" || records[2].Query != "" || records[2].Bang != "" {
+		t.Fatalf("Wrong suppression %v", records)
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(v string) { Config.QueryLogPath = v }(Config.QueryLogPath)
+	defer func(v int) { Config.QueryLogMaxAge = v }(Config.QueryLogMaxAge)
+	defer func() {
+		queryLog.Lock()
+		defer queryLog.Unlock()
+		if queryLog.file != nil {
+			queryLog.file.Close()
+			queryLog.file = nil
+		}
+	}()
+
+	Config.QueryLogPath = path.Join(dir, "querylog.ndjson")
+	Config.QueryLogMaxAge = 1
+
+	req := &SearchRequest{Query: "\\Foo  BAR", Lang: "en", Page: 1}
+	LogQuery(req, &SearchResult{TotalCount: 12, RedirectReason: RedirectLucky, Timing: SearchResultTiming{Total: 42}})
+
+	// Expire the current file
+	queryLog.opened = time.Now().Add(-2 * time.Hour)
+	LogQuery(&SearchRequest{Query: "!g foo bar", Lang: "en", Page: 1}, &SearchResult{RedirectReason: RedirectBang})
+
+	files, _ := filepath.Glob(Config.QueryLogPath + "*")
+	if len(files) != 2 {
+		t.Fatalf("Query log should have been rotated %v", files)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := QueryLogExportCommand(append([]string{"-k", "2"}, files...), &stdout, &stderr); code != 0 {
+		t.Fatalf("Wrong exit code %d: %s", code, stderr.String())
+	}
+
+	records, err := ParseQueryLog(&stdout)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Wrong export %v %v", records, err)
+	}
+
+	for _, record := range records {
+		if record.Time.Minute() != 0 || record.Lang != "en" || record.Page != 1 {
+			t.Fatalf("Wrong record %+v", record)
+		}
+	}
+
+	// The current file sorts before the rotated one
+	if records[0].Query != "" || records[0].Bang != "" || records[0].Redirect != RedirectBang {
+		t.Fatalf("Wrong record %+v", records[0])
+	}
+	if records[1].Query != "" || records[1].Hits != 12 || records[1].Timing.Total != 42 || records[1].Redirect != RedirectLucky {
+		t.Fatalf("Wrong record %+v", records[1])
+	}
+
+	stdout.Reset()
+	QueryLogExportCommand(append([]string{"-k", "1"}, files...), &stdout, &stderr)
+	if !strings.Contains(stdout.String(), `"q":"foo bar"`) || !strings.Contains(stdout.String(), `"q":"!g foo bar"`) ||
+		!strings.Contains(stdout.String(), `"bang":"g"`) {
+		t.Fatalf("Wrong export %s", stdout.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"gopkg.in/olivere/elastic.v3"
+	"strings"
+	"unicode/utf8"
+)
+
+// minRelatedTermLength excludes short significant terms, which are rarely useful refinements.
+const minRelatedTermLength = 3
+
+// RelatedSearch is a refinement of the query, displayed under the hits.
+type RelatedSearch struct {
+	Query string `json:"q"`
+	Href  string `json:"h"`
+}
+
+// buildRelatedAggregation returns the aggregation of the significant terms of the top hits of a search.
+func buildRelatedAggregation() esObject {
+	return esObject{
+		"sampler": esObject{"shard_size": Config.RelatedSampleSize},
+		"aggs": esObject{"terms": esObject{"significant_terms": esObject{
+			"field": Config.RelatedField,
+
+			// Some of the terms are already in the query
+			"size":          Config.RelatedSearches * 2,
+			"min_doc_count": 2,
+		}}},
+	}
+}
+
+// GetRelatedSearches returns refinements of a search: the popular queries with all its words, then
+// the query with one of the significant terms of its top hits. Strict safe search only uses the terms.
+func (req SearchRequest) GetRelatedSearches(textSearchResult *elastic.SearchResult) []RelatedSearch {
+
+	if Config.RelatedSearches <= 0 || req.Page != 1 || req.Cursor != "" {
+		return nil
+	}
+
+	normalized := NormalizeLoggedQuery(req.Query)
+
+	inQuery := make(map[string]bool)
+	for _, word := range strings.Fields(normalized) {
+		inQuery[strings.Trim(word, `-+"`)] = true
+	}
+
+	seen := map[string]bool{normalized: true}
+	var queries []string
+	add := func(q string) {
+		if key := NormalizeLoggedQuery(q); !seen[key] && len(queries) < Config.RelatedSearches {
+			seen[key] = true
+			queries = append(queries, q)
+		}
+	}
+
+	// Significant terms come from the filtered hits, but popular queries aren't filtered.
+	if req.SafeSearch != SafeSearchStrict {
+		for _, popular := range GetRelatedPopularQueries(normalized, req.Lang, Config.RelatedSearches) {
+			add(popular)
+		}
+	}
+
+	if sample, found := textSearchResult.Aggregations.Sampler("related"); found {
+		if terms, found := sample.Aggregations.SignificantTerms("terms"); found {
+			for _, bucket := range terms.Buckets {
+				term := strings.ToLower(bucket.Key)
+				if !inQuery[term] && utf8.RuneCountInString(term) >= minRelatedTermLength {
+					add(req.Query + " " + term)
+				}
+			}
+		}
+	}
+
+	related := make([]RelatedSearch, len(queries))
+	for i, q := range queries {
+		related[i] = RelatedSearch{Query: q, Href: req.WithQuery(q).Href()}
+	}
+	return related
+}
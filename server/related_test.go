@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"gopkg.in/olivere/elastic.v3"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildRelatedAggregation(t *testing.T) {
+
+	defer func(v int) { Config.RelatedSearches = v }(Config.RelatedSearches)
+	defer func(v string) { Config.RelatedField = v }(Config.RelatedField)
+	Config.RelatedSearches = 3
+
+	req := SearchRequest{Query: "foo", Lang: "en", Page: 1}
+	if body, _ := req.BuildTextRequest(); strings.Contains(body, `"aggs"`) {
+		t.Fatal("Significant terms need a not_analyzed field")
+	}
+
+	Config.RelatedField = "body.terms"
+	body, err := req.BuildTextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(body, `"aggs": {"related":{"aggs":{"terms":{"significant_terms":{"field":"body.terms","min_doc_count":2,"size":6}}},"sampler":{"shard_size":100}}}`) {
+		t.Fatalf("Wrong related aggregation %s", body)
+	}
+
+	req.Page = 2
+	if body, _ := req.BuildTextRequest(); strings.Contains(body, `"aggs"`) {
+		t.Fatal("Related searches should only be on the first page")
+	}
+}
+
+func TestGetRelatedSearches(t *testing.T) {
+
+	defer func(v int) { Config.RelatedSearches = v }(Config.RelatedSearches)
+	defer func(v bool) { Config.PopularQueries = v }(Config.PopularQueries)
+	defer func(v int) { Config.PopularMinSessions = v }(Config.PopularMinSessions)
+	defer resetPopularQueries()
+
+	Config.RelatedSearches = 3
+	Config.PopularQueries = true
+	Config.PopularMinSessions = 1
+	resetPopularQueries()
+
+	for _, q := range []string{"foo bar", "foo bar", "bar", "other foo bar", "food bar"} {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.1.1:1234"
+		RecordPopularQuery(r, &SearchRequest{Query: q, Lang: "en", Page: 1}, &SearchResult{Hits: []Hit{{ID: "1"}}})
+	}
+
+	var textSearchResult elastic.SearchResult
+	err := json.Unmarshal([]byte(`{"aggregations": {"related": {"doc_count": 100, "terms": {"doc_count": 100, "buckets": [
+		{"key": "Baz", "doc_count": 10, "bg_count": 20, "score": 2},
+		{"key": "foo", "doc_count": 10, "bg_count": 20, "score": 1.5},
+		{"key": "ab", "doc_count": 10, "bg_count": 20, "score": 1.2},
+		{"key": "qux", "doc_count": 10, "bg_count": 20, "score": 1},
+		{"key": "other", "doc_count": 10, "bg_count": 20, "score": 0.5}
+	]}}}}`), &textSearchResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := SearchRequest{Query: "Bar -foo", Lang: "fr", Page: 1, SafeSearch: "off"}
+
+	related := req.GetRelatedSearches(&textSearchResult)
+	expected := []RelatedSearch{
+		{"Bar -foo baz", "/?g=fr&q=Bar+-foo+baz&safe=off"},
+		{"Bar -foo qux", "/?g=fr&q=Bar+-foo+qux&safe=off"},
+		{"Bar -foo other", "/?g=fr&q=Bar+-foo+other&safe=off"},
+	}
+	if !reflect.DeepEqual(related, expected) {
+		t.Fatalf("Wrong related searches %v", related)
+	}
+
+	// Popular queries come first
+	req = SearchRequest{Query: "Bar", Lang: "en", Page: 1}
+	related = req.GetRelatedSearches(&textSearchResult)
+	if len(related) != 3 || related[0].Query != "foo bar" || related[1].Query != "food bar" || related[2].Query != "other foo bar" {
+		t.Fatalf("Wrong related searches %v", related)
+	}
+
+	req.SafeSearch = SafeSearchStrict
+	related = req.GetRelatedSearches(&textSearchResult)
+	if len(related) != 3 || related[0].Query != "Bar baz" {
+		t.Fatalf("Strict safe search shouldn't use popular queries %v", related)
+	}
+
+	req.Page = 2
+	if req.GetRelatedSearches(&textSearchResult) != nil {
+		t.Fatal("Related searches should only be on the first page")
+	}
+}
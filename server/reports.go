@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReportedQueriesPerMinute bounds the memory used by reports when many different queries are slow.
+const maxReportedQueriesPerMinute = 1000
+
+// ReportedQuery is a query that returned no hits, or that was slower than Config.SlowQueryMs.
+type ReportedQuery struct {
+	Query string `json:"q"`
+	Lang  string `json:"g"`
+	Count int    `json:"count"`
+
+	// MaxTime is the slowest total time of the query, in microseconds.
+	MaxTime uint32 `json:"max_time"`
+
+	// TextBody and DocsBody are the Elasticsearch requests of the slowest search, for slow queries.
+	TextBody string `json:"text_body,omitempty"`
+	DocsBody string `json:"docs_body,omitempty"`
+}
+
+// ReportLang counts the searches of a language in the window.
+type ReportLang struct {
+	Lang        string `json:"g"`
+	Searches    int    `json:"searches"`
+	ZeroResults int    `json:"zero_results"`
+	Slow        int    `json:"slow"`
+}
+
+// QueryReport lists the zero-result and slow queries of the last Config.ReportWindow minutes,
+// most frequent first.
+type QueryReport struct {
+	Window      int             `json:"window"`
+	SlowQueryMs int             `json:"slow_query_ms"`
+	Langs       []ReportLang    `json:"langs"`
+	ZeroResults []ReportedQuery `json:"zero_results"`
+	SlowQueries []ReportedQuery `json:"slow_queries"`
+}
+
+// reportKey groups the searches of a query in a language.
+type reportKey struct {
+	query string
+	lang  string
+}
+
+// reportBucket has the searches of a single minute.
+type reportBucket struct {
+	minute      int64
+	langs       map[string]*ReportLang
+	zeroResults map[reportKey]*ReportedQuery
+	slowQueries map[reportKey]*ReportedQuery
+}
+
+// queryReports is a ring of one bucket per minute of the window.
+var queryReports struct {
+	sync.Mutex
+	buckets []*reportBucket
+}
+
+// RecordQueryReport adds a search to the zero-result and slow query reports.
+func RecordQueryReport(req *SearchRequest, result *SearchResult) {
+
+	if Config.ReportWindow <= 0 || result.Redirect != "" || result.PageLimitReached {
+		return
+	}
+
+	// Further pages and cursors may legitimately be empty.
+	zeroResults := req.Page == 1 && req.Cursor == "" && result.Pinned == nil &&
+		(len(result.Hits) == 0 || (result.Suggestion != nil && result.Suggestion.Corrected))
+	slow := Config.SlowQueryMs > 0 && result.Timing.Total >= uint32(Config.SlowQueryMs*1000)
+
+	key := reportKey{NormalizeLoggedQuery(req.Query), req.Lang}
+
+	queryReports.Lock()
+	defer queryReports.Unlock()
+
+	bucket := getReportBucket(time.Now())
+
+	lang := bucket.langs[key.lang]
+	if lang == nil {
+		lang = &ReportLang{Lang: key.lang}
+		bucket.langs[key.lang] = lang
+	}
+	lang.Searches++
+
+	if zeroResults {
+		lang.ZeroResults++
+		addReportedQuery(bucket.zeroResults, key, result, false)
+	}
+
+	if slow {
+		lang.Slow++
+		addReportedQuery(bucket.slowQueries, key, result, true)
+	}
+}
+
+// getReportBucket returns the bucket of a time, recycling the oldest one. queryReports must be locked.
+func getReportBucket(now time.Time) *reportBucket {
+
+	if len(queryReports.buckets) != Config.ReportWindow {
+		queryReports.buckets = make([]*reportBucket, Config.ReportWindow)
+	}
+
+	minute := now.Unix() / 60
+	i := int(minute % int64(len(queryReports.buckets)))
+
+	bucket := queryReports.buckets[i]
+	if bucket == nil || bucket.minute != minute {
+		bucket = &reportBucket{
+			minute:      minute,
+			langs:       make(map[string]*ReportLang),
+			zeroResults: make(map[reportKey]*ReportedQuery),
+			slowQueries: make(map[reportKey]*ReportedQuery),
+		}
+		queryReports.buckets[i] = bucket
+	}
+	return bucket
+}
+
+// addReportedQuery counts a search in a bucket, keeping the requests of the slowest one.
+func addReportedQuery(queries map[reportKey]*ReportedQuery, key reportKey, result *SearchResult, withRequests bool) {
+
+	reported := queries[key]
+	if reported == nil {
+		if len(queries) >= maxReportedQueriesPerMinute {
+			return
+		}
+		reported = &ReportedQuery{Query: key.query, Lang: key.lang}
+		queries[key] = reported
+	}
+
+	reported.Count++
+
+	if result.Timing.Total >= reported.MaxTime {
+		reported.MaxTime = result.Timing.Total
+		if withRequests && result.requests != nil {
+			reported.TextBody = result.requests.TextBody
+			reported.DocsBody = result.requests.DocsBody
+		}
+	}
+}
+
+// mergeReportedQuery adds the counts of a bucket to a report.
+func mergeReportedQuery(merged map[reportKey]*ReportedQuery, key reportKey, reported *ReportedQuery) {
+
+	total := merged[key]
+	if total == nil {
+		copied := *reported
+		merged[key] = &copied
+		return
+	}
+
+	total.Count += reported.Count
+	if reported.MaxTime >= total.MaxTime {
+		total.MaxTime = reported.MaxTime
+		total.TextBody = reported.TextBody
+		total.DocsBody = reported.DocsBody
+	}
+}
+
+// sortReportedQueries returns the queries of a report, most frequent then slowest first.
+func sortReportedQueries(merged map[reportKey]*ReportedQuery) []ReportedQuery {
+
+	queries := make([]ReportedQuery, 0, len(merged))
+	for _, reported := range merged {
+		queries = append(queries, *reported)
+	}
+
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Count != queries[j].Count {
+			return queries[i].Count > queries[j].Count
+		}
+		if queries[i].MaxTime != queries[j].MaxTime {
+			return queries[i].MaxTime > queries[j].MaxTime
+		}
+		return queries[i].Query < queries[j].Query
+	})
+
+	if len(queries) > Config.ReportSize {
+		queries = queries[:Config.ReportSize]
+	}
+	return queries
+}
+
+// GetQueryReport aggregates the buckets of the last Config.ReportWindow minutes.
+func GetQueryReport(now time.Time) QueryReport {
+
+	report := QueryReport{
+		Window:      Config.ReportWindow,
+		SlowQueryMs: Config.SlowQueryMs,
+		Langs:       []ReportLang{},
+	}
+
+	langs := make(map[string]*ReportLang)
+	zeroResults := make(map[reportKey]*ReportedQuery)
+	slowQueries := make(map[reportKey]*ReportedQuery)
+
+	queryReports.Lock()
+
+	minute := now.Unix() / 60
+	for _, bucket := range queryReports.buckets {
+		if bucket == nil || bucket.minute <= minute-int64(Config.ReportWindow) || bucket.minute > minute {
+			continue
+		}
+
+		for name, lang := range bucket.langs {
+			total := langs[name]
+			if total == nil {
+				total = &ReportLang{Lang: name}
+				langs[name] = total
+			}
+			total.Searches += lang.Searches
+			total.ZeroResults += lang.ZeroResults
+			total.Slow += lang.Slow
+		}
+		for key, reported := range bucket.zeroResults {
+			mergeReportedQuery(zeroResults, key, reported)
+		}
+		for key, reported := range bucket.slowQueries {
+			mergeReportedQuery(slowQueries, key, reported)
+		}
+	}
+
+	queryReports.Unlock()
+
+	for _, lang := range langs {
+		report.Langs = append(report.Langs, *lang)
+	}
+	sort.Slice(report.Langs, func(i, j int) bool {
+		if report.Langs[i].Searches != report.Langs[j].Searches {
+			return report.Langs[i].Searches > report.Langs[j].Searches
+		}
+		return report.Langs[i].Lang < report.Langs[j].Lang
+	})
+
+	report.ZeroResults = sortReportedQueries(zeroResults)
+	report.SlowQueries = sortReportedQueries(slowQueries)
+
+	return report
+}
+
+// AdminReportsHandler returns the zero-result and slow query reports (/admin/reports), as HTML
+// or as JSON with format=json.
+func AdminReportsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report := GetQueryReport(time.Now())
+
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := Templates["reports.html"].Execute(w, report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryReport(t *testing.T) {
+
+	defer func(v int) { Config.SlowQueryMs = v }(Config.SlowQueryMs)
+	defer func(v int) { Config.ReportSize = v }(Config.ReportSize)
+	defer func(v int) { Config.ReportWindow = v }(Config.ReportWindow)
+	Config.SlowQueryMs = 100
+	Config.ReportSize = 1
+	Config.ReportWindow = 60
+
+	queryReports.Lock()
+	queryReports.buckets = nil
+	queryReports.Unlock()
+
+	fast := &SearchResult{Hits: []Hit{{ID: "1"}}, Timing: SearchResultTiming{Total: 1000}}
+	empty := &SearchResult{Timing: SearchResultTiming{Total: 2000}}
+	slow := &SearchResult{Hits: []Hit{{ID: "1"}}, Timing: SearchResultTiming{Total: 300000}, requests: &SearchDebug{TextBody: "{1}", DocsBody: "{2}"}}
+	slower := &SearchResult{Hits: []Hit{{ID: "1"}}, Timing: SearchResultTiming{Total: 500000}, requests: &SearchDebug{TextBody: "{3}"}}
+	corrected := &SearchResult{Hits: []Hit{{ID: "1"}}, Suggestion: &Suggestion{Query: "foo", Corrected: true}}
+
+	RecordQueryReport(&SearchRequest{Query: "foo", Lang: "en", Page: 1}, fast)
+	RecordQueryReport(&SearchRequest{Query: "Nothing  here", Lang: "en", Page: 1}, empty)
+	RecordQueryReport(&SearchRequest{Query: "nothing here", Lang: "en", Page: 1}, empty)
+	RecordQueryReport(&SearchRequest{Query: "fooo", Lang: "fr", Page: 1}, corrected)
+	RecordQueryReport(&SearchRequest{Query: "nothing here", Lang: "en", Page: 2}, empty)
+	RecordQueryReport(&SearchRequest{Query: "big query", Lang: "fr", Page: 1}, slow)
+	RecordQueryReport(&SearchRequest{Query: "big query", Lang: "fr", Page: 1}, slower)
+	RecordQueryReport(&SearchRequest{Query: "!g bang", Lang: "en", Page: 1}, &SearchResult{Redirect: "http://google.com"})
+
+	report := GetQueryReport(time.Now())
+
+	if len(report.Langs) != 2 || report.Langs[0] != (ReportLang{"en", 4, 2, 0}) || report.Langs[1] != (ReportLang{"fr", 3, 1, 2}) {
+		t.Fatalf("Wrong counts per language %+v", report.Langs)
+	}
+
+	if len(report.ZeroResults) != 1 || report.ZeroResults[0] != (ReportedQuery{Query: "nothing here", Lang: "en", Count: 2, MaxTime: 2000}) {
+		t.Fatalf("Wrong zero results %+v", report.ZeroResults)
+	}
+
+	if len(report.SlowQueries) != 1 || report.SlowQueries[0] != (ReportedQuery{Query: "big query", Lang: "fr", Count: 2, MaxTime: 500000, TextBody: "{3}"}) {
+		t.Fatalf("Wrong slow queries %+v", report.SlowQueries)
+	}
+
+	// Searches leave the window after Config.ReportWindow minutes
+	later := GetQueryReport(time.Now().Add(time.Duration(Config.ReportWindow) * time.Minute))
+	if len(later.Langs) != 0 || len(later.ZeroResults) != 0 || len(later.SlowQueries) != 0 {
+		t.Fatalf("Wrong report after the window %+v", later)
+	}
+
+	Config.ReportWindow = 0
+	RecordQueryReport(&SearchRequest{Query: "nothing here", Lang: "en", Page: 1}, empty)
+	if disabled := GetQueryReport(time.Now()); len(disabled.Langs) != 0 || len(disabled.ZeroResults) != 0 {
+		t.Fatalf("Reports should be disabled %+v", disabled)
+	}
+}
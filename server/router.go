@@ -27,6 +27,24 @@ func CreateRouter() http.Handler {
 	// Main JSON search route
 	router.Handler("GET", "/api/search", commonMiddleware.ThenFunc(APISearchHandler))
 
+	// Click beacons of ranking experiments
+	router.Handler("POST", "/api/click", commonMiddleware.ThenFunc(ClickHandler))
+
+	// Win/loss counts of ranking experiments, for admins
+	router.Handler("GET", "/admin/experiments", commonMiddleware.ThenFunc(AdminExperimentsHandler))
+
+	// Metrics of the server, like the shadow search stats, for admins
+	router.Handler("GET", "/admin/metrics", commonMiddleware.ThenFunc(AdminMetricsHandler))
+
+	// Zero-result and slow query reports, for admins
+	router.Handler("GET", "/admin/reports", commonMiddleware.ThenFunc(AdminReportsHandler))
+
+	// Autocomplete JSON route
+	router.Handler("GET", "/api/suggest", commonMiddleware.ThenFunc(APISuggestHandler))
+
+	// OpenSearch description, to add us as a search engine in browsers
+	router.Handler("GET", "/opensearch.xml", commonMiddleware.ThenFunc(OpenSearchHandler))
+
 	// Static asset directories
 	ServeStaticDirectory(router, "js", true)
 	ServeStaticDirectory(router, "css", true)
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Safe search levels, selected with the "safe" URL parameter or cookie.
+const (
+	// SafeSearchStrict filters out documents likely to be adult content.
+	SafeSearchStrict = "strict"
+
+	// SafeSearchModerate demotes documents very likely to be adult content.
+	SafeSearchModerate = "moderate"
+
+	// SafeSearchOff doesn't change the results.
+	SafeSearchOff = "off"
+)
+
+// safeSearchCookieMaxAge is the lifetime of the safe search preference cookie, in seconds.
+const safeSearchCookieMaxAge = 365 * 24 * 3600
+
+// IsValidSafeSearch returns true for the known safe search levels.
+func IsValidSafeSearch(level string) bool {
+	return level == SafeSearchStrict || level == SafeSearchModerate || level == SafeSearchOff
+}
+
+// getSafeSearch returns the safe search level of a request, from the URL, then the preference cookie.
+func getSafeSearch(r *http.Request) string {
+
+	if level := r.FormValue("safe"); IsValidSafeSearch(level) {
+		return level
+	}
+
+	if cookie, err := r.Cookie("safe"); err == nil && IsValidSafeSearch(cookie.Value) {
+		return cookie.Value
+	}
+
+	return Config.SafeSearch
+}
+
+// setSafeSearchCookie remembers a safe search level chosen in the URL, only when it changes the
+// current one. Going back to the default level forgets it.
+func setSafeSearchCookie(w http.ResponseWriter, r *http.Request, level string) {
+
+	if !IsValidSafeSearch(r.FormValue("safe")) {
+		return
+	}
+
+	current := Config.SafeSearch
+	if cookie, err := r.Cookie("safe"); err == nil && IsValidSafeSearch(cookie.Value) {
+		current = cookie.Value
+	}
+	if level == current {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     "safe",
+		Value:    level,
+		Path:     "/",
+		MaxAge:   safeSearchCookieMaxAge,
+		HttpOnly: true,
+	}
+	if level == Config.SafeSearch {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// WithSafeSearch returns the same search with another safe search level.
+func (req SearchRequest) WithSafeSearch(level string) SearchRequest {
+	other := req
+	other.SafeSearch = level
+	return other
+}
+
+// buildSafeSearchFilter returns a filter excluding adult content from the text index, if needed.
+func (req SearchRequest) buildSafeSearchFilter() esObject {
+
+	if req.SafeSearch != SafeSearchStrict {
+		return nil
+	}
+
+	// Documents without a score are kept.
+	return esObject{"bool": esObject{"must_not": esObject{"range": esObject{
+		Config.SafeSearchField: esObject{"gte": Config.SafeSearchStrictThreshold},
+	}}}}
+}
+
+// getSafeSearchThreshold returns the SafeSearchField value above which documents are excluded
+// from requests that can't demote them: "moderate" excludes the documents it would demote.
+func getSafeSearchThreshold(level string) (float64, bool) {
+	switch level {
+	case SafeSearchStrict:
+		return Config.SafeSearchStrictThreshold, true
+	case SafeSearchModerate:
+		return Config.SafeSearchModerateThreshold, true
+	}
+	return 0, false
+}
+
+// buildSafeSearchExclusion returns a filter excluding adult content from the text index, for
+// requests like completions and aggregations which don't rank documents.
+func buildSafeSearchExclusion(level string) esObject {
+
+	threshold, found := getSafeSearchThreshold(level)
+	if !found {
+		return nil
+	}
+
+	return esObject{"bool": esObject{"must_not": esObject{"range": esObject{
+		Config.SafeSearchField: esObject{"gte": threshold},
+	}}}}
+}
+
+// IsSafeHit returns false if a document found outside of the text search, like a navigational
+// hit, would be excluded by buildSafeSearchExclusion.
+func (req SearchRequest) IsSafeHit(id string) (bool, error) {
+
+	threshold, found := getSafeSearchThreshold(req.SafeSearch)
+	if !found || Config.TestData {
+		return true, nil
+	}
+
+	body, err := json.Marshal(esObject{
+		"size": 0,
+		"query": esObject{"bool": esObject{"filter": []esObject{
+			{"ids": esObject{"values": []string{id}}},
+			{"range": esObject{Config.SafeSearchField: esObject{"gte": threshold}}},
+		}}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	backend := req.getBackend()
+
+	result, _, err := ElasticsearchRequest(backend.TextClient, "/"+backend.TextIndex+"/page/_search", string(body))
+	if err != nil {
+		return false, err
+	}
+
+	return result.Hits == nil || result.Hits.TotalHits == 0, nil
+}
+
+// buildSafeSearchFunction returns a function_score function demoting adult content, if needed.
+func (req SearchRequest) buildSafeSearchFunction() string {
+
+	if req.SafeSearch != SafeSearchModerate {
+		return ""
+	}
+
+	return fmt.Sprintf(`{
+      "filter": {
+        "range": {
+          %q: {"gte": %g}
+        }
+      },
+      "weight": %g
+    }`, Config.SafeSearchField, Config.SafeSearchModerateThreshold, Config.SafeSearchModerateWeight)
+}
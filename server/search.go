@@ -3,17 +3,16 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/commonsearch/cosr-front/server/query"
 	"gopkg.in/olivere/elastic.v3"
 	"html"
+	"log"
 	"net/url"
 	"regexp"
 	"strings"
 	"time"
 )
 
-// Remove most of the useless spaces in HTML.
-var termSplitRegexp = regexp.MustCompile(`\s+`)
-
 // SearchResultTiming is used to measure timings at various steps in the request, in microseconds.
 type SearchResultTiming struct {
 
@@ -35,6 +34,16 @@ type Hit struct {
 	URL     string `json:"u"`
 	Title   string `json:"t"`
 	Summary string `json:"s"`
+
+	// More is set on the last hit of a domain when some others were collapsed.
+	More *DomainMore `json:"mr,omitempty"`
+
+	// Explain is the breakdown of the score of the hit, with explain=1.
+	Explain *HitExplanation `json:"ex,omitempty"`
+
+	// Position is the position of an interleaved hit from 1, sent in click beacons. The server knows
+	// which ranking profile picked it from the impression, see TeamDraftInterleave()
+	Position int `json:"xn,omitempty"`
 }
 
 // SearchResult defines the result for a query, passed to the template.
@@ -45,6 +54,38 @@ type SearchResult struct {
 	Timing     SearchResultTiming `json:"t,omitempty"`
 	TotalCount int64              `json:"c,omitempty"`
 	Extra      string             `json:"e,omitempty"`
+	Suggestion *Suggestion        `json:"sg,omitempty"`
+	Facets     []DomainFacet      `json:"f,omitempty"`
+
+	// PageLimitReached is true if the page is beyond Config.MaxPage.
+	PageLimitReached bool `json:"pl,omitempty"`
+
+	// NextCursor continues a cursor walk through the results.
+	NextCursor string `json:"nc,omitempty"`
+
+	// Answer is an instant answer to the query, see StartAnswers()
+	Answer *Answer `json:"a,omitempty"`
+
+	// RedirectReason tells why Redirect is set: RedirectBang, RedirectLucky or RedirectNavigational.
+	RedirectReason string `json:"rr,omitempty"`
+
+	// Pinned is the page named by a domain or URL query, displayed before the hits.
+	Pinned *Hit `json:"pn,omitempty"`
+
+	// GoTo is the URL named by the query when it isn't indexed, see GetGoToURL()
+	GoTo string `json:"gt,omitempty"`
+
+	// Debug has the Elasticsearch requests and profile, with explain=1.
+	Debug *SearchDebug `json:"dbg,omitempty"`
+
+	// Related are refinements of the query, see GetRelatedSearches()
+	Related []RelatedSearch `json:"rl,omitempty"`
+
+	// Impression identifies pages of interleaved hits in click beacons, see Experiment.
+	Impression *ExperimentImpression `json:"xp,omitempty"`
+
+	// requests are the Elasticsearch requests, kept for the slow query report even without explain=1.
+	requests *SearchDebug
 }
 
 // SearchRequest entirely defines a search request.
@@ -52,11 +93,85 @@ type SearchRequest struct {
 	Query string `json:"q"`
 	Page  int    `json:"p"`
 	Lang  string `json:"g"`
+
+	// Detected is set when Lang was guessed from the query, see DetectLanguage()
+	Detected *LangGuess `json:"dl,omitempty"`
+
+	// Exact disables the automatic spelling correction of queries without results.
+	Exact bool `json:"x,omitempty"`
+
+	// Snippets selects how hit summaries are built, see GetSnippetMode()
+	Snippets string `json:"sn,omitempty"`
+
+	// Cursor walks through results beyond Config.MaxPage in the API. See CursorStart.
+	Cursor string `json:"cu,omitempty"`
+
+	// Operators are echoed back to clients so they can be displayed separately from the query.
+	Operators []SearchOperator `json:"o,omitempty"`
+
+	// SafeSearch is the safe search level, see IsValidSafeSearch()
+	SafeSearch string `json:"safe,omitempty"`
+
+	// UserAgent is the User-Agent header of the request, for instant answers.
+	UserAgent string `json:"-"`
+
+	// Explain asks for ranking explanations, see IsExplainAllowed()
+	Explain bool `json:"explain,omitempty"`
+
+	// Profile is the name of the ranking profile asked with profile=, see GetRankingProfile()
+	Profile string `json:"profile,omitempty"`
+
+	// Experiment interleaves the hits of two ranking profiles, see AssignExperiment()
+	Experiment *Experiment `json:"-"`
+
+	// Backend is set on shadow requests to search the candidate indexes, see StartShadowSearch()
+	Backend *SearchBackend `json:"-"`
 }
 
-// Href returns the relative URL of this search.
+// SearchOperator is a field restriction found in the query, like site:example.com
+type SearchOperator struct {
+	Name    string `json:"f"`
+	Value   string `json:"v"`
+	Negated bool   `json:"n,omitempty"`
+
+	// Rest is the query without this operator
+	Rest string `json:"r"`
+}
+
+// GetSearchOperators returns all the field restrictions in a query.
+func GetSearchOperators(q string) []SearchOperator {
+
+	parsed := query.Parse(q)
+	fields, negated := query.Fields(parsed)
+
+	operators := make([]SearchOperator, len(fields))
+	for i, field := range fields {
+		operators[i] = SearchOperator{
+			Name:    field.Name,
+			Value:   field.Value,
+			Negated: negated[i],
+		}
+		if rest := query.Remove(parsed, field); rest != nil {
+			operators[i].Rest = rest.String()
+		}
+	}
+	return operators
+}
+
+// Href returns the relative URL of this search. The safe search level is only in the URL when it
+// isn't the default one.
 // Same function is implemented on the JavaScript side
 func (req SearchRequest) Href() string {
+	return req.href(false)
+}
+
+// SafeSearchHref returns the relative URL of this search with another safe search level, always
+// explicit so that choosing it is remembered.
+func (req SearchRequest) SafeSearchHref(level string) string {
+	return req.WithSafeSearch(level).href(true)
+}
+
+func (req SearchRequest) href(explicitSafeSearch bool) string {
 
 	var components []string
 
@@ -72,6 +187,26 @@ func (req SearchRequest) Href() string {
 		components = append(components, "q="+url.QueryEscape(req.Query))
 	}
 
+	if req.SafeSearch != "" && (explicitSafeSearch || req.SafeSearch != Config.SafeSearch) {
+		components = append(components, "safe="+url.QueryEscape(req.SafeSearch))
+	}
+
+	if req.Snippets != "" && req.Query != "" {
+		components = append(components, "sn="+url.QueryEscape(req.Snippets))
+	}
+
+	if req.Exact && req.Query != "" {
+		components = append(components, "x=1")
+	}
+
+	if req.Explain && req.Query != "" {
+		components = append(components, "explain=1")
+	}
+
+	if req.Profile != "" && req.Query != "" {
+		components = append(components, "profile="+url.QueryEscape(req.Profile))
+	}
+
 	if len(components) == 0 {
 		return "/"
 	}
@@ -80,6 +215,16 @@ func (req SearchRequest) Href() string {
 
 }
 
+// WithQuery returns the first page of the same search with another query.
+func (req SearchRequest) WithQuery(q string) SearchRequest {
+	other := req
+	other.Query = q
+	other.Page = 1
+	other.Exact = false
+	other.Operators = GetSearchOperators(q)
+	return other
+}
+
 // PreviousPageHref returns the relative URL of the previous page for this search.
 func (req SearchRequest) PreviousPageHref() string {
 	if req.Page < 2 {
@@ -103,56 +248,120 @@ func (req SearchRequest) NextPageHref() string {
 // BuildTextRequest returns a JSON-encoded Elasticsearch query body for the text index.
 func (req SearchRequest) BuildTextRequest() (string, error) {
 
-	var scoringFunctions []string
+	profile := req.GetRankingProfile()
+
+	textQuery := BuildTextQuery(query.Parse(req.Query), profile)
+
+	if safeSearchFilter := req.buildSafeSearchFilter(); safeSearchFilter != nil {
+		textQuery = esObject{"bool": esObject{
+			"must":   textQuery,
+			"filter": safeSearchFilter,
+		}}
+	}
+
+	jsonQuery, err := json.Marshal(textQuery)
+	if err != nil {
+		return "", err
+	}
 
-	jsonQuery, err := json.Marshal(req.Query)
+	scoringFunctions, err := profile.BuildScoringFunctions(req.Lang)
 	if err != nil {
 		return "", err
 	}
 
-	scoringFunctions = append(scoringFunctions, `{
-	  	"field_value_factor": {
-	      "field": "rank",
-	      "factor": 1,
-	      "missing": 0
-	    }
-	}`)
+	// The safe search function comes last: it is only explained for the hits its filter matches.
+	if safeSearchFunction := req.buildSafeSearchFunction(); safeSearchFunction != "" {
+		scoringFunctions = append(scoringFunctions, safeSearchFunction)
+	}
 
-	if req.Lang != "all" {
-		scoringFunctions = append(scoringFunctions, fmt.Sprintf(`{
-		  	"field_value_factor": {
-                "field": "lang_%s",
-                "missing": 0.002
-            }
-		}`, req.Lang))
+	// Optional parameters of the request body
+	var extraParams []string
+
+	// Cursor pages only walk through the hits.
+	firstPage := req.Page == 1 && req.Cursor == ""
+
+	aggs := esObject{}
+	if Config.DomainFacets > 0 && firstPage {
+		aggs["domains"] = buildDomainFacetsAggregation()
+	}
+	if Config.RelatedSearches > 0 && Config.RelatedField != "" && firstPage {
+		aggs["related"] = buildRelatedAggregation()
+	}
+	if len(aggs) > 0 {
+		jsonAggs, err := json.Marshal(aggs)
+		if err != nil {
+			return "", err
+		}
+		extraParams = append(extraParams, fmt.Sprintf(`"aggs": %s`, jsonAggs))
+	}
+
+	if Config.SpellcheckMaxHits > 0 && firstPage {
+		suggest, err := req.buildSpellcheckSuggest()
+		if err != nil {
+			return "", err
+		}
+		if suggest != "" {
+			extraParams = append(extraParams, suggest)
+		}
+	}
+
+	highlight, err := req.buildHighlightParam("text")
+	if err != nil {
+		return "", err
+	}
+	if highlight != "" {
+		extraParams = append(extraParams, highlight)
+	}
+
+	if req.Explain {
+		extraParams = append(extraParams, `"explain": true`, `"profile": true`)
+	}
+
+	from := (req.Page - 1) * Config.ResultPageSize
+
+	// Cursors walk through the hits with a scroll, from the start.
+	if req.Cursor != "" {
+		from = 0
 	}
 
 	// TODO: remove whitespace?
 	textEsBody := fmt.Sprintf(`{
       "query": {
         "function_score": {
-          "query": {
-            "multi_match": {
-              "query": %s,
-              "minimum_should_match": "-25%%",
-              "type": "cross_fields",
-	          "tie_breaker": 0.5,
-	          "fields": ["title^3", "body", "url_words^2", "domain_words^8"]
-            }
-
-          },
+          "query": %s,
           "functions": [%s]
         }
       },
       "from": %d,
-      "size": %d
-    }`, jsonQuery, strings.Join(scoringFunctions, ","), (req.Page-1)*Config.ResultPageSize, Config.ResultPageSize)
+      "size": %d%s
+    }`, jsonQuery, strings.Join(scoringFunctions, ","), from, Config.ResultPageSize,
+		joinExtraParams(extraParams))
 
 	return textEsBody, nil
 }
 
+// getScoringFunctionNames returns the names of the functions of the text request, in order.
+func (req SearchRequest) getScoringFunctionNames() []string {
+
+	names := req.GetRankingProfile().GetScoringFunctionNames(req.Lang)
+
+	if req.buildSafeSearchFunction() != "" {
+		names = append(names, Config.SafeSearchField)
+	}
+
+	return names
+}
+
+// joinExtraParams formats optional "key": value parameters to be appended to a JSON object.
+func joinExtraParams(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return ",\n      " + strings.Join(params, ",\n      ")
+}
+
 // BuildDocsRequest returns a JSON-encoded Elasticsearch query body for the docs index.
-func BuildDocsRequest(textSearchResult *elastic.SearchResult) string {
+func BuildDocsRequest(textSearchResult *elastic.SearchResult, extraParams []string) string {
 
 	// Collect the IDs
 	ids := make([]string, len(textSearchResult.Hits.Hits))
@@ -174,8 +383,8 @@ func BuildDocsRequest(textSearchResult *elastic.SearchResult) string {
             }
           }
         }
-      }
-    }`, strings.Join(ids, `","`))
+      }%s
+    }`, strings.Join(ids, `","`), joinExtraParams(extraParams))
 
 }
 
@@ -191,24 +400,101 @@ func (req SearchRequest) PerformSearch() (*SearchResult, error) {
 
 	if redirect != "" {
 		page.Redirect = redirect
+		page.RedirectReason = RedirectBang
 		return &page, nil
 	}
 
-	if Config.TestData {
-		return req.GenerateTestData(), nil
+	if req.IsPageLimitReached() {
+		page.PageLimitReached = true
+		return &page, nil
+	}
+
+	// "\query" goes straight to the first hit
+	lucky := req.IsLucky()
+	if lucky {
+		req = req.WithoutLuckyPrefix()
+	}
+
+	// Instant answers are computed while we wait for Elasticsearch, and never replace the hits.
+	answer := req.StartAnswers()
+
+	// Domains and URLs may go straight to the page they name. They are looked up during the text search.
+	navigational := req.StartNavigationalHit()
+
+	result, err := req.performIndexSearch()
+
+	// The pinned page is an extra: the search goes on without it if the lookup failed.
+	found := <-navigational
+	pinned := found.hit
+	if found.err != nil {
+		log.Printf("Navigational lookup failed: %s", found.err)
+		pinned = nil
+	}
+
+	if pinned != nil && (lucky || Config.NavigationalMode == NavigationalRedirect) && IsRedirectURL(pinned.URL) {
+		page.Redirect = pinned.URL
+		page.RedirectReason = RedirectNavigational
+		return &page, nil
 	}
 
-	textEsBody, err := req.BuildTextRequest()
 	if err != nil {
 		return nil, err
 	}
 
-	// fmt.Println(textEsBody)
+	// Shadow searches compare the index hits, before the pinned page is moved out of them.
+	indexURLs := getHitURLs(result)
 
-	textSearchResult, textRequestTime, err := ElasticsearchRequest(
-		ElasticsearchTextClient,
-		"/text/page/_search",
-		textEsBody)
+	result.Answer = <-answer
+
+	if pinned != nil {
+		result.PinHit(pinned)
+	} else {
+		result.GoTo = req.GetGoToURL()
+	}
+
+	// Moderate safe search only demotes adult content, which can't be the page we go to.
+	if lucky && len(result.Hits) > 0 && IsRedirectURL(result.Hits[0].URL) {
+		safe, err := req.IsSafeHit(result.Hits[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		if safe {
+			result.Redirect = result.Hits[0].URL
+			result.RedirectReason = RedirectLucky
+		}
+	}
+
+	if result.Redirect == "" {
+		req.StartShadowSearch(indexURLs)
+	}
+
+	return result, nil
+}
+
+// performIndexSearch performs the Elasticsearch requests of PerformSearch.
+func (req SearchRequest) performIndexSearch() (*SearchResult, error) {
+
+	page := SearchResult{}
+
+	if Config.TestData {
+		return req.GenerateTestData(), nil
+	}
+
+	page.requests = &SearchDebug{}
+	if req.Explain {
+		page.Debug = page.requests
+	}
+
+	var textSearchResult *elastic.SearchResult
+	var textRequestTime time.Duration
+	var teams map[string]string
+	var err error
+
+	if req.Experiment != nil {
+		textSearchResult, teams, textRequestTime, err = req.performInterleavedTextRequest()
+	} else {
+		textSearchResult, textRequestTime, err = req.performTextRequest(page.requests)
+	}
 
 	if err != nil {
 		return nil, err
@@ -217,20 +503,39 @@ func (req SearchRequest) PerformSearch() (*SearchResult, error) {
 	page.Timing.TextRequest = uint32(textRequestTime.Seconds() * 1000000)
 	page.Timing.TextQuery = uint32(textSearchResult.TookInMillis * 1000)
 
+	if textSearchResult.Hits != nil {
+		page.TotalCount = textSearchResult.Hits.TotalHits
+	}
+
+	page.Facets = req.GetDomainFacets(textSearchResult)
+	page.Related = req.GetRelatedSearches(textSearchResult)
+
 	// No results!
 	if textSearchResult.Hits == nil || len(textSearchResult.Hits.Hits) == 0 {
-		return &page, nil
+		return req.addSpellcheck(&page, textSearchResult), nil
 	}
 
 	// TODO: use ES count to determine that
-	// TODO: also return textSearchResult.Hits.TotalHits
-	page.HasMore = (len(textSearchResult.Hits.Hits) >= Config.ResultPageSize)
-	page.TotalCount = textSearchResult.Hits.TotalHits
-	docsEsBody := BuildDocsRequest(textSearchResult)
+	page.HasMore = (len(textSearchResult.Hits.Hits) >= Config.ResultPageSize) && (req.Page < Config.MaxPage || req.Cursor != "")
+	page.NextCursor = req.getNextCursor(textSearchResult)
+
+	var docsExtraParams []string
+	highlight, err := req.buildHighlightParam("docs")
+	if err != nil {
+		return nil, err
+	}
+	if highlight != "" {
+		docsExtraParams = append(docsExtraParams, highlight)
+	}
+
+	docsEsBody := BuildDocsRequest(textSearchResult, docsExtraParams)
+	page.requests.DocsBody = docsEsBody
+
+	backend := req.getBackend()
 
 	docsSearchResult, docsRequestTime, err := ElasticsearchRequest(
-		ElasticsearchDocsClient,
-		"/docs/page/_search?fields=title,summary,url&size=100",
+		backend.DocsClient,
+		"/"+backend.DocsIndex+"/page/_search?fields=title,summary,url&size=100",
 		docsEsBody)
 
 	if err != nil {
@@ -247,6 +552,16 @@ func (req SearchRequest) PerformSearch() (*SearchResult, error) {
 
 	hitsByIds := make(map[string]*Hit, len(docsSearchResult.Hits.Hits))
 
+	// Query-dependent snippets, from whichever index was asked to highlight them
+	snippets := make(map[string]string)
+	for _, hits := range [][]*elastic.SearchHit{textSearchResult.Hits.Hits, docsSearchResult.Hits.Hits} {
+		for _, hit := range hits {
+			if snippet := GetSnippet(hit); snippet != "" {
+				snippets[hit.Id] = snippet
+			}
+		}
+	}
+
 	// Iterate through results and convert them in their final struct
 	for _, hit := range docsSearchResult.Hits.Hits {
 		hitsByIds[hit.Id] = &Hit{
@@ -256,29 +571,58 @@ func (req SearchRequest) PerformSearch() (*SearchResult, error) {
 			Summary: hit.Fields["summary"].([]interface{})[0].(string)}
 
 		hitsByIds[hit.Id].Title = AddHighlighting(hitsByIds[hit.Id].Title, req.Query)
-		hitsByIds[hit.Id].Summary = AddHighlighting(hitsByIds[hit.Id].Summary, req.Query)
+
+		// Fall back on the stored summary if no fragment matched.
+		if snippets[hit.Id] != "" {
+			hitsByIds[hit.Id].Summary = snippets[hit.Id]
+		} else {
+			hitsByIds[hit.Id].Summary = AddHighlighting(hitsByIds[hit.Id].Summary, req.Query)
+		}
 
 	}
 
+	var functionNames []string
+	if req.Explain {
+		functionNames = req.getScoringFunctionNames()
+	}
+
 	// Restore the original order of the text results.
 	for _, hit := range textSearchResult.Hits.Hits {
 		if hitsByIds[hit.Id] != nil {
+			if req.Explain {
+				hitsByIds[hit.Id].Explain = GetHitExplanation(hit, functionNames)
+			}
 			page.Hits = append(page.Hits, *hitsByIds[hit.Id])
 		}
 	}
 
-	return &page, nil
+	page.Hits = req.CollapseDomains(page.Hits)
+
+	if req.Experiment != nil && len(page.Hits) > 0 {
+		hitTeams := make([]string, len(page.Hits))
+		for i := range page.Hits {
+			hitTeams[i] = teams[page.Hits[i].ID]
+			page.Hits[i].Position = i + 1
+		}
+		page.Impression = NewExperimentImpression(req.Experiment.Name, hitTeams)
+	}
+
+	return req.addSpellcheck(&page, textSearchResult), nil
 }
 
-// AddHighlighting wraps the query terms in bold inside Title and Summary
-func AddHighlighting(text string, query string) string {
+// AddHighlighting wraps the query terms in bold inside Title and Summary.
+// Excluded terms are never highlighted and phrases are only highlighted as a whole.
+func AddHighlighting(text string, q string) string {
 
 	textResult := html.EscapeString(text)
 
-	// We want to highlight each term individually
-	for _, term := range termSplitRegexp.Split(query, -1) {
-		termEscaped := regexp.QuoteMeta(term)
-		re, err := regexp.Compile("(?i)(^|\\W)(" + termEscaped + ")($|\\W)")
+	// We want to highlight each term or phrase individually
+	for _, term := range query.Highlights(query.Parse(q)) {
+		words := strings.Fields(term)
+		for i, word := range words {
+			words[i] = regexp.QuoteMeta(html.EscapeString(word))
+		}
+		re, err := regexp.Compile("(?i)(^|\\W)(" + strings.Join(words, "\\s+") + ")($|\\W)")
 		if err != nil {
 			continue
 		}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/commonsearch/cosr-front/server/query"
+	"gopkg.in/olivere/elastic.v3"
+	"strings"
 	"testing"
 )
 
@@ -94,3 +98,261 @@ func TestHighlighing(t *testing.T) {
 	}
 
 }
+
+func TestHighlighingQueryLanguage(t *testing.T) {
+	t.Parallel()
+
+	if AddHighlighting("xx yy zz", "xx -yy") != "<b>xx</b> yy zz" {
+		t.Fatal("Excluded terms shouldn't be highlighted")
+	}
+
+	if AddHighlighting("xx yy zz yy", `"yy zz"`) != "xx <b>yy zz</b> yy" {
+		t.Fatal("Phrases should be highlighted as a whole")
+	}
+
+	if AddHighlighting("xx yy zz", "xx OR zz") != "<b>xx</b> yy <b>zz</b>" {
+		t.Fatal("OR shouldn't be highlighted")
+	}
+
+	if AddHighlighting("a & b", `"a & b"`) != "<b>a &amp; b</b>" {
+		t.Fatal("Phrases should match escaped HTML")
+	}
+}
+
+func TestBuildTextQuery(t *testing.T) {
+	t.Parallel()
+
+	plain := toJSON(BuildTextQuery(query.Parse("foo  bar"), GetRankingProfile("standard", "en")))
+	if !strings.HasPrefix(plain, `{"multi_match":`) || !strings.Contains(plain, `"query":"foo bar"`) {
+		t.Fatalf("Plain queries should be a single multi_match: %s", plain)
+	}
+
+	advanced := toJSON(BuildTextQuery(query.Parse(`foo "bar baz" -qux (a OR b)`), GetRankingProfile("standard", "en")))
+
+	for _, expected := range []string{
+		`"must_not":[{"multi_match":{"fields":["title^3","body","url_words^2","domain_words^8"],"minimum_should_match":"-25%","query":"qux"`,
+		`{"match_phrase":{"title":{"boost":3,"query":"bar baz"}}}`,
+		`"should":[{"multi_match"`,
+		`"minimum_should_match":1`,
+	} {
+		if !strings.Contains(advanced, expected) {
+			t.Fatalf("Expected %s in %s", expected, advanced)
+		}
+	}
+
+	if toJSON(BuildTextQuery(query.Parse("-foo"), GetRankingProfile("standard", "en"))) != `{"bool":{"must":[{"match_all":{}}],"must_not":[`+toJSON(BuildTextQuery(query.Parse("foo"), GetRankingProfile("standard", "en")))+`]}}` {
+		t.Fatal("Exclusions alone should match everything else")
+	}
+}
+
+func TestTruncateQuery(t *testing.T) {
+
+	defer func(max int) { Config.MaxQueryTerms = max }(Config.MaxQueryTerms)
+	Config.MaxQueryTerms = 3
+
+	if q, extra := TruncateQuery("a   b c"); q != "a   b c" || extra != "" {
+		t.Fatal("Short queries shouldn't be changed")
+	}
+
+	if q, extra := TruncateQuery(`a "b c d" e f`); q != `a "b c d" e` || extra != "f" {
+		t.Fatal("Phrases should count as one term")
+	}
+}
+
+func TestBuildTextQueryOperators(t *testing.T) {
+	t.Parallel()
+
+	body := toJSON(BuildTextQuery(query.Parse("foo site:www.Example.com/page intitle:\"a b\" -inurl:x-y"), GetRankingProfile("standard", "en")))
+
+	for _, expected := range []string{
+		`"filter":[{"match_phrase":{"domain_words":"example com"}}]`,
+		`{"match_phrase":{"title":"a b"}}`,
+		`"must_not":[{"match_phrase":{"url_words":"x y"}}]`,
+		`"query":"foo"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %s in %s", expected, body)
+		}
+	}
+
+	for _, q := range []string{"()", `""`, `( "" )`} {
+		if toJSON(BuildTextQuery(query.Parse(q), GetRankingProfile("standard", "en"))) != `{"bool":{"must_not":{"match_all":{}}}}` {
+			t.Fatalf("%s should match no documents", q)
+		}
+	}
+
+	if toJSON(BuildTextQuery(query.Parse("AND"), GetRankingProfile("standard", "en"))) != toJSON(BuildTextQuery(query.Term{Text: "AND"}, GetRankingProfile("standard", "en"))) {
+		t.Fatal("Operators alone should be searched as words")
+	}
+
+	if toJSON(BuildTextQuery(query.Parse("site:example.com"), GetRankingProfile("standard", "en"))) != `{"bool":{"filter":[{"match_phrase":{"domain_words":"example com"}}],"must":[{"match_all":{}}]}}` {
+		t.Fatal("Operators alone should filter all documents")
+	}
+}
+
+func TestSearchOperators(t *testing.T) {
+	t.Parallel()
+
+	operators := GetSearchOperators("foo site:example.com -intitle:bar")
+
+	if len(operators) != 2 {
+		t.Fatalf("Wrong operators %v", operators)
+	}
+
+	if operators[0] != (SearchOperator{Name: "site", Value: "example.com", Rest: "foo -intitle:bar"}) {
+		t.Fatalf("Wrong site operator %v", operators[0])
+	}
+
+	if operators[1] != (SearchOperator{Name: "intitle", Value: "bar", Negated: true, Rest: "foo site:example.com"}) {
+		t.Fatalf("Wrong intitle operator %v", operators[1])
+	}
+
+	if (SearchRequest{Query: "x site:y", Lang: "fr", Page: 3}).WithQuery(operators[0].Rest).Href() != "/?g=fr&q=foo+-intitle%3Abar" {
+		t.Fatal("Wrong operator removal Href")
+	}
+}
+
+func TestSpellcheck(t *testing.T) {
+	t.Parallel()
+
+	if CorrectQuery("Helo wrld", "hello world") != "hello world" {
+		t.Fatal("Wrong correction")
+	}
+
+	if CorrectQuery(`Helo "wrld x" -foo site:example.com`, "hello") != `hello "wrld x" -foo site:example.com` {
+		t.Fatal("Operators should be kept")
+	}
+
+	if CorrectQuery("Hello world", "hello world") != "" {
+		t.Fatal("Case changes aren't corrections")
+	}
+
+	if CorrectQuery("the helo", "hello") != "" {
+		t.Fatal("Unaligned corrections should be ignored")
+	}
+
+	body, err := (SearchRequest{Query: `helo -wrld`, Lang: "fr"}).buildSpellcheckSuggest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"text": "helo"`) || !strings.Contains(body, `"filter":{"exists":{"field":"lang_fr"}}`) {
+		t.Fatalf("Wrong spellcheck request %s", body)
+	}
+
+	// The suggester is part of the text request of first pages
+	body, _ = (SearchRequest{Query: "helo", Lang: "fr", Page: 1}).BuildTextRequest()
+	if !strings.Contains(body, `"suggest": {`) {
+		t.Fatalf("Text request should have a suggester %s", body)
+	}
+	body, _ = (SearchRequest{Query: "helo", Lang: "fr", Page: 2}).BuildTextRequest()
+	if strings.Contains(body, `"suggest"`) {
+		t.Fatalf("Further pages should have no suggester %s", body)
+	}
+
+	var textSearchResult elastic.SearchResult
+	json.Unmarshal([]byte(`{"suggest": {"spellcheck": [{"text": "helo", "offset": 0, "length": 4, "options": [{"text": "hello", "score": 0.5}]}]}}`), &textSearchResult)
+	corrected := (SearchRequest{Query: "helo -wrld", Lang: "fr", Page: 1}).getSpellcheckSuggestion(&textSearchResult)
+	if corrected == nil || corrected.Query != "hello -wrld" {
+		t.Fatalf("Wrong correction %v", corrected)
+	}
+
+	if (SearchRequest{Query: "x", Exact: true}).Href() != "/?q=x&x=1" {
+		t.Fatal("Wrong exact Href")
+	}
+}
+
+func TestPaging(t *testing.T) {
+	t.Parallel()
+
+	if (SearchRequest{Query: "x", Page: Config.MaxPage}).IsPageLimitReached() {
+		t.Fatal("MaxPage should be allowed")
+	}
+
+	if !(SearchRequest{Query: "x", Page: Config.MaxPage + 1}).IsPageLimitReached() {
+		t.Fatal("Pages after MaxPage should not be allowed")
+	}
+
+	if (SearchRequest{Query: "x", Page: 1, Cursor: "abc"}).IsPageLimitReached() {
+		t.Fatal("Cursors have no page limit")
+	}
+}
+
+func TestScrollCursor(t *testing.T) {
+	t.Parallel()
+
+	req := SearchRequest{Query: "foo", Lang: "en", Page: 1, SafeSearch: SafeSearchModerate, Cursor: CursorStart}
+
+	body, err := req.BuildTextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"from": 0,`) {
+		t.Fatalf("Cursors should start from the first hit: %s", body)
+	}
+	if strings.Contains(body, `"aggs"`) || strings.Contains(body, `"suggest"`) {
+		t.Fatalf("Cursor pages shouldn't have aggregations or spellcheck: %s", body)
+	}
+
+	hits := make([]*elastic.SearchHit, Config.ResultPageSize)
+	for i := range hits {
+		hits[i] = &elastic.SearchHit{Id: "x"}
+	}
+
+	req.Cursor = req.getNextCursor(&elastic.SearchResult{ScrollId: "xxxscroll", Hits: &elastic.SearchHits{Hits: hits}})
+	if req.Cursor == "" {
+		t.Fatal("Full pages should have a next cursor")
+	}
+	if scrollID, err := req.GetScrollID(); scrollID != "xxxscroll" || err != nil {
+		t.Fatalf("Cursors should continue their scroll: %s %v", scrollID, err)
+	}
+
+	for _, other := range []SearchRequest{
+		req.WithQuery("bar"),
+		req.WithSafeSearch(SafeSearchOff),
+		{Query: "foo", Lang: "fr", Page: 1, SafeSearch: SafeSearchModerate, Cursor: req.Cursor},
+		{Query: "foo", Lang: "en", Page: 1, SafeSearch: SafeSearchModerate, Cursor: "garbage"},
+	} {
+		if _, err := other.GetScrollID(); err != ErrInvalidCursor {
+			t.Fatalf("Cursors should only be valid for their search: %v", other)
+		}
+	}
+
+	if req.getNextCursor(&elastic.SearchResult{ScrollId: "xxxscroll", Hits: &elastic.SearchHits{Hits: hits[:1]}}) != "" {
+		t.Fatal("The last page has no next cursor")
+	}
+}
+
+func TestSafeSearchRequest(t *testing.T) {
+	t.Parallel()
+
+	if (SearchRequest{Query: "x", Lang: "en", SafeSearch: "off"}).Href() != "/?g=en&q=x&safe=off" {
+		t.Fatal("Wrong safe search Href")
+	}
+
+	if (SearchRequest{Query: "x", Lang: "en", SafeSearch: "moderate"}).Href() != "/?g=en&q=x" {
+		t.Fatal("The default safe search level shouldn't be in URLs")
+	}
+
+	if (SearchRequest{Query: "x", Lang: "en", SafeSearch: "strict"}).SafeSearchHref("moderate") != "/?g=en&q=x&safe=moderate" {
+		t.Fatal("Safe search links should be explicit")
+	}
+
+	strict, _ := (SearchRequest{Query: "x", Lang: "en", Page: 1, SafeSearch: SafeSearchStrict}).BuildTextRequest()
+	if !strings.Contains(strict, `"filter":{"bool":{"must_not":{"range":{"adult":{"gte":0.2}}}}}`) {
+		t.Fatalf("Strict safe search should filter adult content: %s", strict)
+	}
+
+	moderate, _ := (SearchRequest{Query: "x", Lang: "en", Page: 1, SafeSearch: SafeSearchModerate}).BuildTextRequest()
+	if !strings.Contains(moderate, `"adult": {"gte": 0.6}`) || strings.Contains(moderate, `"must_not"`) {
+		t.Fatalf("Moderate safe search should demote adult content: %s", moderate)
+	}
+
+	if buildSafeSearchExclusion(SafeSearchOff) != nil || buildSafeSearchExclusion(SafeSearchStrict) == nil {
+		t.Fatal("Wrong safe search exclusion")
+	}
+
+	off, _ := (SearchRequest{Query: "x", Lang: "en", Page: 1, SafeSearch: SafeSearchOff}).BuildTextRequest()
+	if strings.Contains(off, `"adult"`) {
+		t.Fatal("Safe search can be turned off")
+	}
+}
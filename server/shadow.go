@@ -0,0 +1,201 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// ShadowStats compare the results of shadow searches with the main ones. They are published
+// with expvar as "shadow".
+type ShadowStats struct {
+
+	// Sampled is the number of searches picked by Config.ShadowSampleRate
+	Sampled int64 `json:"sampled"`
+
+	// Skipped is the number of sampled searches dropped because Config.ShadowConcurrency was reached.
+	Skipped int64 `json:"skipped"`
+
+	// Errors is the number of shadow searches that failed.
+	Errors int64 `json:"errors"`
+
+	// Compared is the number of shadow searches compared with the main ones.
+	Compared int64 `json:"compared"`
+
+	// JaccardMean and RBOMean are the mean overlaps of the hit URLs, between 0 and 1.
+	JaccardMean float64 `json:"jaccard_mean"`
+	RBOMean     float64 `json:"rbo_mean"`
+}
+
+// rboPersistence is the p parameter of rank-biased overlap: the weight of deeper ranks.
+const rboPersistence = 0.9
+
+var shadowStats struct {
+	sync.Mutex
+	ShadowStats
+}
+
+// shadowSlots limits the number of concurrent shadow searches.
+var shadowSlots chan struct{}
+
+var initShadowSlots sync.Once
+
+func init() {
+	expvar.Publish("shadow", expvar.Func(func() interface{} {
+		return GetShadowStats()
+	}))
+}
+
+// GetShadowStats returns a copy of the current shadow stats.
+func GetShadowStats() ShadowStats {
+	shadowStats.Lock()
+	defer shadowStats.Unlock()
+	return shadowStats.ShadowStats
+}
+
+// StartShadowSearch replays a sample of the searches on ShadowBackend in the background, and compares
+// their hits with mainURLs, the hit URLs of the main index search. It never waits for the shadow search.
+func (req SearchRequest) StartShadowSearch(mainURLs []string) {
+
+	// Interleaved results can't be compared with a single ranking, and cursors belong to the main index.
+	if ShadowBackend == nil || req.Backend != nil || req.Experiment != nil || req.Cursor != "" ||
+		rand.Float64() >= Config.ShadowSampleRate {
+		return
+	}
+
+	initShadowSlots.Do(func() {
+		shadowSlots = make(chan struct{}, Config.ShadowConcurrency)
+	})
+
+	shadowStats.Lock()
+	shadowStats.Sampled++
+	shadowStats.Unlock()
+
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		shadowStats.Lock()
+		shadowStats.Skipped++
+		shadowStats.Unlock()
+		return
+	}
+
+	shadow := req
+	shadow.Backend = ShadowBackend
+	shadow.Experiment = nil
+	shadow.Explain = false
+
+	go func() {
+		defer func() { <-shadowSlots }()
+
+		shadowResult, err := shadow.performIndexSearch()
+
+		shadowStats.Lock()
+		defer shadowStats.Unlock()
+
+		if err != nil {
+			shadowStats.Errors++
+			log.Printf("Shadow search failed: %s", err)
+			return
+		}
+
+		shadowURLs := getHitURLs(shadowResult)
+		jaccard := Jaccard(mainURLs, shadowURLs)
+		rbo := RankBiasedOverlap(mainURLs, shadowURLs, rboPersistence)
+
+		// Running means
+		shadowStats.Compared++
+		n := float64(shadowStats.Compared)
+		shadowStats.JaccardMean += (jaccard - shadowStats.JaccardMean) / n
+		shadowStats.RBOMean += (rbo - shadowStats.RBOMean) / n
+
+		log.Printf("Shadow search: jaccard=%.3f rbo=%.3f hits=%d/%d", jaccard, rbo, len(mainURLs), len(shadowURLs))
+	}()
+}
+
+// getHitURLs returns the URLs of the hits of a result, in order.
+func getHitURLs(result *SearchResult) []string {
+	urls := make([]string, len(result.Hits))
+	for i, hit := range result.Hits {
+		urls[i] = hit.URL
+	}
+	return urls
+}
+
+// Jaccard returns the size of the intersection of two lists divided by the size of their union.
+func Jaccard(a []string, b []string) float64 {
+
+	setA := make(map[string]bool)
+	for _, x := range a {
+		setA[x] = true
+	}
+	setB := make(map[string]bool)
+	for _, x := range b {
+		setB[x] = true
+	}
+
+	intersection := 0
+	for x := range setA {
+		if setB[x] {
+			intersection++
+		}
+	}
+
+	union := len(setA) + len(setB) - intersection
+	if union == 0 {
+		return 1
+	}
+	return float64(intersection) / float64(union)
+}
+
+// RankBiasedOverlap compares two rankings, giving more weight to the first ranks. It is the overlap
+// at each depth d, weighted by p^(d-1), and normalized so that identical rankings have an overlap of 1.
+func RankBiasedOverlap(a []string, b []string, p float64) float64 {
+
+	depth := len(a)
+	if len(b) > depth {
+		depth = len(b)
+	}
+	if depth == 0 {
+		return 1
+	}
+
+	seenA := make(map[string]bool)
+	seenB := make(map[string]bool)
+	overlap := 0
+	sum := 0.0
+
+	for d := 1; d <= depth; d++ {
+		if d <= len(a) {
+			x := a[d-1]
+			if seenB[x] && !seenA[x] {
+				overlap++
+			}
+			seenA[x] = true
+		}
+		if d <= len(b) {
+			x := b[d-1]
+			if seenA[x] && !seenB[x] {
+				overlap++
+			}
+			seenB[x] = true
+		}
+		sum += math.Pow(p, float64(d-1)) * float64(overlap) / float64(d)
+	}
+
+	return sum * (1 - p) / (1 - math.Pow(p, float64(depth)))
+}
+
+// AdminMetricsHandler returns the expvar metrics, including the shadow stats (/admin/metrics)
+func AdminMetricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOverlapMetrics(t *testing.T) {
+	t.Parallel()
+
+	if Jaccard([]string{"a", "b", "c"}, []string{"c", "b", "a"}) != 1 || Jaccard(nil, nil) != 1 {
+		t.Fatal("Same hits should fully overlap")
+	}
+
+	if Jaccard([]string{"a", "b"}, []string{"b", "c"}) != 1.0/3 {
+		t.Fatal("Wrong Jaccard index")
+	}
+
+	if math.Abs(RankBiasedOverlap([]string{"a", "b", "c"}, []string{"a", "b", "c"}, 0.9)-1) > 1e-9 {
+		t.Fatal("Same rankings should fully overlap")
+	}
+
+	if RankBiasedOverlap([]string{"a", "b"}, []string{"c", "d"}, 0.9) != 0 {
+		t.Fatal("Disjoint rankings should not overlap")
+	}
+
+	swapped := RankBiasedOverlap([]string{"a", "b", "c"}, []string{"c", "b", "a"}, 0.9)
+	reordered := RankBiasedOverlap([]string{"a", "b", "c"}, []string{"a", "c", "b"}, 0.9)
+	if !(swapped < reordered && reordered < 1) {
+		t.Fatalf("Differences in the first ranks should weigh more: %f %f", swapped, reordered)
+	}
+}
+
+func TestShadowSearch(t *testing.T) {
+
+	defer func(v *SearchBackend) { ShadowBackend = v }(ShadowBackend)
+	defer func(v float64) { Config.ShadowSampleRate = v }(Config.ShadowSampleRate)
+
+	ShadowBackend = &SearchBackend{TextIndex: "text2", DocsIndex: "docs2"}
+	Config.ShadowSampleRate = 1
+
+	before := GetShadowStats()
+
+	req := SearchRequest{Query: "xxxteststring", Lang: "en", Page: 1}
+	result, err := req.PerformSearch()
+	if err != nil || len(result.Hits) == 0 {
+		t.Fatal("Shadow searches should not change the results")
+	}
+
+	// Pinned pages are compared where the index ranked them
+	req = SearchRequest{Query: "www.example.com/page/2", Lang: "en", Page: 1}
+	if result, err := req.PerformSearch(); err != nil || result.Pinned == nil {
+		t.Fatal("Should pin the page named by the query")
+	}
+
+	for i := 0; i < 100 && GetShadowStats().Compared < before.Compared+2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := GetShadowStats()
+	if stats.Sampled != before.Sampled+2 || stats.Compared != before.Compared+2 || stats.JaccardMean != 1 || stats.RBOMean != 1 {
+		t.Fatalf("Wrong shadow stats %+v", stats)
+	}
+}
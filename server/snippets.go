@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/commonsearch/cosr-front/server/query"
+	"gopkg.in/olivere/elastic.v3"
+	"strings"
+)
+
+// Snippet modes, selected with the "sn" URL parameter.
+const (
+	// SnippetSummary displays the stored summary of each document.
+	SnippetSummary = "summary"
+
+	// SnippetHighlight displays the fragments of each document matching the query.
+	SnippetHighlight = "highlight"
+)
+
+// snippetFragmentSeparator joins highlighted fragments of the same document.
+const snippetFragmentSeparator = " &hellip; "
+
+// GetSnippetMode returns the snippet mode of this search.
+func (req SearchRequest) GetSnippetMode() string {
+	switch req.Snippets {
+	case SnippetSummary, SnippetHighlight:
+		return req.Snippets
+	}
+	return Config.SnippetMode
+}
+
+// buildHighlightParam returns the JSON-encoded highlight parameter of a search body, if the
+// snippets of this search should come from the given index.
+func (req SearchRequest) buildHighlightParam(index string) (string, error) {
+
+	if req.GetSnippetMode() != SnippetHighlight || Config.SnippetIndex != index {
+		return "", nil
+	}
+
+	highlight := esObject{
+		"pre_tags":  []string{"<b>"},
+		"post_tags": []string{"</b>"},
+		"encoder":   "html",
+		"fields": esObject{
+			Config.SnippetField: esObject{
+				"fragment_size":       Config.SnippetFragmentSize,
+				"number_of_fragments": 2,
+			},
+		},
+	}
+
+	// The docs index is only queried by IDs: we need to tell it what to highlight.
+	if index == "docs" {
+		highlight["highlight_query"] = BuildTextQuery(query.Parse(req.Query), req.GetRankingProfile())
+	}
+
+	jsonHighlight, err := json.Marshal(highlight)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`"highlight": %s`, jsonHighlight), nil
+}
+
+// GetSnippet returns the highlighted fragments of a hit, or an empty string if there are none.
+// Fragments are already HTML-escaped by Elasticsearch.
+func GetSnippet(hit *elastic.SearchHit) string {
+	fragments := hit.Highlight[Config.SnippetField]
+	for i, fragment := range fragments {
+		fragments[i] = strings.TrimSpace(fragment)
+	}
+	return strings.Join(fragments, snippetFragmentSeparator)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"gopkg.in/olivere/elastic.v3"
+	"strings"
+	"testing"
+)
+
+func TestSnippetMode(t *testing.T) {
+	t.Parallel()
+
+	if (SearchRequest{}).GetSnippetMode() != Config.SnippetMode {
+		t.Fatal("Snippet mode should default to the config")
+	}
+
+	if (SearchRequest{Snippets: "highlight"}).GetSnippetMode() != SnippetHighlight {
+		t.Fatal("Snippet mode should be selectable")
+	}
+
+	if (SearchRequest{Query: "x", Lang: "en", Snippets: "highlight"}).Href() != "/?g=en&q=x&sn=highlight" {
+		t.Fatal("Wrong snippet mode Href")
+	}
+}
+
+func TestHighlightRequest(t *testing.T) {
+	t.Parallel()
+
+	req := SearchRequest{Query: "foo -bar", Lang: "en", Page: 1, Snippets: SnippetHighlight}
+
+	body, err := req.BuildTextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(body, `"highlight": {"encoder":"html","fields":{"body":{"fragment_size":150,"number_of_fragments":2}}`) {
+		t.Fatalf("Missing highlight in %s", body)
+	}
+
+	docsHighlight, _ := req.buildHighlightParam("docs")
+	if docsHighlight != "" {
+		t.Fatal("Only one index should highlight snippets")
+	}
+
+	req.Snippets = SnippetSummary
+	body, _ = req.BuildTextRequest()
+	if strings.Contains(body, `"highlight"`) {
+		t.Fatal("Summary snippets don't need highlighting")
+	}
+}
+
+func TestGetSnippet(t *testing.T) {
+	t.Parallel()
+
+	hit := &elastic.SearchHit{Highlight: elastic.SearchHitHighlight{
+		"body": []string{" a <b>foo</b> ", "b &amp; <b>foo</b>"},
+	}}
+
+	if GetSnippet(hit) != "a <b>foo</b> &hellip; b &amp; <b>foo</b>" {
+		t.Fatalf("Wrong snippet %s", GetSnippet(hit))
+	}
+
+	if GetSnippet(&elastic.SearchHit{}) != "" {
+		t.Fatal("Hits without fragments should have empty snippets")
+	}
+}
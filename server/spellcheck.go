@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/commonsearch/cosr-front/server/query"
+	"gopkg.in/olivere/elastic.v3"
+	"strings"
+)
+
+// Suggestion is a spelling correction of the query.
+type Suggestion struct {
+	Query string `json:"q"`
+	Href  string `json:"h"`
+
+	// Corrected is true when the results are for the suggested query instead of the original one.
+	Corrected bool `json:"c,omitempty"`
+
+	// OriginalHref searches for the original query without any correction.
+	OriginalHref string `json:"o,omitempty"`
+}
+
+// buildSpellcheckSuggest returns the phrase suggester of the text request, as an optional parameter of
+// its body, so that corrections cost no extra request. Suggestions are only kept if they match some
+// documents in the language of the search. It returns an empty string if there is nothing to correct.
+func (req SearchRequest) buildSpellcheckSuggest() (string, error) {
+
+	terms := query.Terms(query.Parse(req.Query))
+	if len(terms) == 0 {
+		return "", nil
+	}
+
+	collateQuery := esObject{"match": esObject{
+		Config.SpellcheckField: esObject{
+			"query":    "{{suggestion}}",
+			"operator": "and",
+		},
+	}}
+
+	if langFilter := buildLangFilter(req.Lang); langFilter != nil {
+		collateQuery = esObject{"bool": esObject{
+			"must":   collateQuery,
+			"filter": langFilter,
+		}}
+	}
+
+	jsonCollate, err := json.Marshal(collateQuery)
+	if err != nil {
+		return "", err
+	}
+
+	jsonText, err := json.Marshal(strings.Join(terms, " "))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`"suggest": {
+        "spellcheck": {
+          "text": %s,
+          "phrase": {
+            "field": "%s",
+            "size": 1,
+            "max_errors": 2,
+            "direct_generator": [{
+              "field": "%s",
+              "suggest_mode": "always",
+              "min_word_length": 3
+            }],
+            "collate": {
+              "query": {
+                "inline": %s
+              },
+              "prune": false
+            }
+          }
+        }
+      }`, jsonText, Config.SpellcheckField, Config.SpellcheckField, jsonCollate), nil
+}
+
+// getSpellcheckSuggestion reads the correction of the query words from a text search result.
+// It returns nil if there is no better spelling.
+func (req SearchRequest) getSpellcheckSuggestion(textSearchResult *elastic.SearchResult) *SearchRequest {
+
+	for _, suggestion := range textSearchResult.Suggest["spellcheck"] {
+		for _, option := range suggestion.Options {
+			if corrected := CorrectQuery(req.Query, option.Text); corrected != "" {
+				other := req.WithQuery(corrected)
+				return &other
+			}
+		}
+	}
+
+	return nil
+}
+
+// CorrectQuery replaces the words of a query with the ones from a suggester, keeping its operators.
+// It returns an empty string if the correction can't be applied or doesn't change anything.
+func CorrectQuery(q string, correction string) string {
+
+	parsed := query.Parse(q)
+	words := query.Terms(parsed)
+	corrections := strings.Fields(correction)
+
+	// The suggester may have dropped some stopwords or punctuation: we can't align the words anymore.
+	if len(words) != len(corrections) {
+		return ""
+	}
+
+	changed := false
+	for i, word := range words {
+		if strings.ToLower(word) != corrections[i] {
+			words[i] = corrections[i]
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+
+	return query.ReplaceTerms(parsed, words).String()
+}
+
+// addSpellcheck adds a spelling suggestion to a SearchResult with few hits. When there
+// were no hits at all, it may run the suggested query instead.
+func (req SearchRequest) addSpellcheck(page *SearchResult, textSearchResult *elastic.SearchResult) *SearchResult {
+
+	if Config.SpellcheckMaxHits <= 0 || page.TotalCount >= Config.SpellcheckMaxHits || req.Page > 1 {
+		return page
+	}
+
+	corrected := req.getSpellcheckSuggestion(textSearchResult)
+	if corrected == nil {
+		return page
+	}
+
+	page.Suggestion = &Suggestion{Query: corrected.Query, Href: corrected.Href()}
+
+	if page.TotalCount > 0 || !Config.SpellcheckAutoCorrect || req.Exact {
+		return page
+	}
+
+	// We don't want to correct the correction.
+	corrected.Exact = true
+
+	// Only the index search: answers, navigational hits and shadow searches are for the original query.
+	correctedPage, err := corrected.performIndexSearch()
+	if err != nil || len(correctedPage.Hits) == 0 {
+		return page
+	}
+
+	original := req
+	original.Exact = true
+
+	correctedPage.Suggestion = &Suggestion{
+		Query:        corrected.Query,
+		Href:         page.Suggestion.Href,
+		Corrected:    true,
+		OriginalHref: original.Href(),
+	}
+
+	return correctedPage
+}
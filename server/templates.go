@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"log"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"text/template"
 )
 
@@ -72,6 +74,25 @@ func getConfig() ConfigSpec {
 	return Config
 }
 
+// ms formats a duration in microseconds as milliseconds.
+func ms(us uint32) string {
+	return fmt.Sprintf("%.1f", float64(us)/1000)
+}
+
+// templateFuncs are the functions available in all templates.
+var templateFuncs = template.FuncMap{
+	"simplifyURL": simplifyURL,
+	"toJSON":      toJSON,
+	"getConfig":   getConfig,
+	"add":         add,
+	"ms":          ms,
+	"T":           translate,
+	"upper":       strings.ToUpper,
+	"searchLanguages": func() []string {
+		return SearchLanguages
+	},
+}
+
 // ParseTemplate returns a pre-processed and parsed template.
 func ParseTemplate(filepath string) *template.Template {
 	cnt, err := ioutil.ReadFile(path.Join(Config.PathFront, "templates/"+filepath))
@@ -80,24 +101,43 @@ func ParseTemplate(filepath string) *template.Template {
 		log.Fatal(err)
 	}
 
-	funcMap := template.FuncMap{
-		"simplifyURL": simplifyURL,
-		"toJSON":      toJSON,
-		"getConfig":   getConfig,
-		"add":         add,
+	t, err := template.New(filepath).Funcs(templateFuncs).Parse(preprocessTemplate(string(cnt)))
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	t, err := template.New(filepath).Funcs(funcMap).Parse(preprocessTemplate(string(cnt)))
+	return t
+}
+
+// ParseTemplateDir parses all the templates of a directory together, each one named after its file.
+func ParseTemplateDir(dir string) *template.Template {
+
+	files, err := filepath.Glob(path.Join(Config.PathFront, "templates", dir, "*.html"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	t := template.New(dir).Funcs(templateFuncs)
+
+	for _, file := range files {
+		cnt, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if _, err := t.New(path.Base(file)).Parse(preprocessTemplate(string(cnt))); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	return t
 }
 
 // LoadTemplates loads and parses all known templates at startup.
 func LoadTemplates() {
 	Templates["index.html"] = ParseTemplate("index.html")
+	Templates["answers"] = ParseTemplateDir("answers")
+	Templates["reports.html"] = ParseTemplate("reports.html")
 }
 
 // Used to generate tabIndex for search links
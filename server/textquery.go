@@ -0,0 +1,217 @@
+package main
+
+import (
+	"github.com/commonsearch/cosr-front/server/query"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// esObject is a JSON object in an Elasticsearch query body.
+type esObject map[string]interface{}
+
+// BuildTextQuery translates a parsed query into an Elasticsearch query clause for the text index.
+// Fields and their boosts come from the ranking profile.
+//   - Words are matched together with a single cross_fields multi_match, like plain queries always were
+//   - Phrases become match_phrase queries on each field
+//   - Excluded clauses become must_not
+//   - OR groups become bool should clauses
+//   - site: and inurl: become filters on domain_words and url_words, intitle: a match_phrase on title
+//
+// Queries without any word, like "()", match no documents.
+func BuildTextQuery(n query.Node, profile *RankingProfile) esObject {
+
+	clause := buildTextClause(n, profile)
+	if clause == nil {
+		return esObject{"bool": esObject{"must_not": esObject{"match_all": esObject{}}}}
+	}
+	return clause
+}
+
+func buildTextClause(n query.Node, profile *RankingProfile) esObject {
+
+	switch n := n.(type) {
+
+	case query.Term:
+		return buildWordsClause([]string{n.Text}, profile)
+
+	case query.Phrase:
+		return buildPhraseClause(n.Text(), profile)
+
+	case query.Field:
+		return buildBoolClause([]query.Node{n}, nil, profile)
+
+	case query.Not:
+		return buildBoolClause(nil, []query.Node{n.Node}, profile)
+
+	case query.And:
+		var must, mustNot []query.Node
+		for _, child := range n.Nodes {
+			if not, isNot := child.(query.Not); isNot {
+				mustNot = append(mustNot, not.Node)
+			} else {
+				must = append(must, child)
+			}
+		}
+		return buildBoolClause(must, mustNot, profile)
+
+	case query.Or:
+		should := make([]esObject, 0, len(n.Nodes))
+		for _, child := range n.Nodes {
+			should = append(should, BuildTextQuery(child, profile))
+		}
+		return esObject{"bool": esObject{
+			"should":               should,
+			"minimum_should_match": 1,
+		}}
+	}
+
+	return nil
+}
+
+// buildBoolClause requires all the 'must' nodes and excludes all the 'mustNot' nodes.
+func buildBoolClause(must []query.Node, mustNot []query.Node, profile *RankingProfile) esObject {
+
+	var mustClauses, mustNotClauses, filterClauses []esObject
+	var words []string
+
+	// All the words are grouped in one multi_match, regardless of their position.
+	for _, node := range must {
+		if term, isTerm := node.(query.Term); isTerm {
+			words = append(words, term.Text)
+		}
+	}
+	if len(words) > 0 {
+		mustClauses = append(mustClauses, buildWordsClause(words, profile))
+	}
+
+	for _, node := range must {
+		switch node := node.(type) {
+		case query.Term:
+		case query.Field:
+			// Restrictions on the URL don't contribute to the score.
+			if node.Name == query.FieldInTitle {
+				mustClauses = append(mustClauses, buildFieldClause(node))
+			} else {
+				filterClauses = append(filterClauses, buildFieldClause(node))
+			}
+		default:
+			mustClauses = append(mustClauses, BuildTextQuery(node, profile))
+		}
+	}
+
+	for _, node := range mustNot {
+		if field, isField := node.(query.Field); isField {
+			mustNotClauses = append(mustNotClauses, buildFieldClause(field))
+		} else {
+			mustNotClauses = append(mustNotClauses, BuildTextQuery(node, profile))
+		}
+	}
+
+	if len(mustNotClauses) == 0 && len(filterClauses) == 0 && len(mustClauses) == 1 {
+		return mustClauses[0]
+	}
+
+	if len(mustClauses) == 0 {
+		mustClauses = append(mustClauses, esObject{"match_all": esObject{}})
+	}
+
+	boolClause := esObject{"must": mustClauses}
+	if len(mustNotClauses) > 0 {
+		boolClause["must_not"] = mustNotClauses
+	}
+	if len(filterClauses) > 0 {
+		boolClause["filter"] = filterClauses
+	}
+
+	return esObject{"bool": boolClause}
+}
+
+// buildWordsClause matches a list of words across all the fields.
+func buildWordsClause(words []string, profile *RankingProfile) esObject {
+	return esObject{"multi_match": esObject{
+		"query":                strings.Join(words, " "),
+		"minimum_should_match": profile.MinimumShouldMatch,
+		"type":                 "cross_fields",
+		"tie_breaker":          profile.TieBreaker,
+		"fields":               profile.Fields,
+	}}
+}
+
+// buildPhraseClause matches an exact phrase in any of the fields.
+func buildPhraseClause(phrase string, profile *RankingProfile) esObject {
+
+	phrases := make([]esObject, len(profile.Fields))
+	for i, field := range profile.Fields {
+		name, boost := splitFieldBoost(field)
+		phrases[i] = esObject{"match_phrase": esObject{
+			name: esObject{"query": phrase, "boost": boost},
+		}}
+	}
+
+	return esObject{"dis_max": esObject{
+		"queries":     phrases,
+		"tie_breaker": profile.TieBreaker,
+	}}
+}
+
+// splitFieldBoost splits a "field^boost" definition.
+func splitFieldBoost(field string) (string, float64) {
+	parts := strings.SplitN(field, "^", 2)
+	if len(parts) == 1 {
+		return field, 1
+	}
+	boost, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return parts[0], 1
+	}
+	return parts[0], boost
+}
+
+// buildFieldClause matches a field restriction like site:example.com
+func buildFieldClause(f query.Field) esObject {
+
+	switch f.Name {
+
+	case query.FieldSite:
+		return esObject{"match_phrase": esObject{
+			"domain_words": strings.Join(splitURLWords(stripURLScheme(f.Value)), " "),
+		}}
+
+	case query.FieldInURL:
+		return esObject{"match_phrase": esObject{
+			"url_words": strings.Join(splitURLWords(f.Value), " "),
+		}}
+	}
+
+	return esObject{"match_phrase": esObject{
+		"title": f.Value,
+	}}
+}
+
+// stripURLScheme keeps only the domain of a URL, without its "www." prefix.
+func stripURLScheme(value string) string {
+	value = strings.ToLower(value)
+	if i := strings.Index(value, "://"); i >= 0 {
+		value = value[i+3:]
+	}
+	if i := strings.IndexAny(value, "/?#"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimPrefix(value, "www.")
+}
+
+// splitURLWords splits a domain or a URL in words, like url_words and domain_words are indexed.
+func splitURLWords(value string) []string {
+	return strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// buildLangFilter restricts documents to a language. It returns nil for "all".
+func buildLangFilter(lang string) esObject {
+	if lang == "all" || lang == "" {
+		return nil
+	}
+	return esObject{"exists": esObject{"field": "lang_" + lang}}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unit is a unit of measure. A value v in this unit is v*Factor+Offset in the base unit of its dimension.
+type unit struct {
+	Symbol    string
+	Dimension string
+	Factor    float64
+	Offset    float64
+}
+
+// units are the units we can convert, by name. Base units are the meter, the kilogram, the liter,
+// the kelvin, the second, the meter per second, the square meter and the byte.
+var units = make(map[string]*unit)
+
+// unitNames are the keys of units, longest first, to find "km" before "m".
+var unitNames []string
+
+// conversionProvider answers unit conversions like "5 miles in km".
+type conversionProvider struct{}
+
+func (conversionProvider) Name() string {
+	return "conversion"
+}
+
+func (conversionProvider) Match(req SearchRequest) bool {
+	return conversionRegexp.MatchString(strings.ToLower(req.Query))
+}
+
+func (conversionProvider) Answer(ctx context.Context, req SearchRequest) (*Answer, error) {
+
+	input, output, ok := Convert(req.Query)
+	if !ok {
+		return nil, nil
+	}
+
+	return &Answer{
+		Confidence: 1,
+		Data:       CalculationAnswer{Input: input, Output: output},
+		Template:   "calculator.html",
+	}, nil
+}
+
+func init() {
+
+	RegisterAnswerProvider(conversionProvider{}, 50*time.Millisecond)
+
+	definitions := []struct {
+		unit  unit
+		names []string
+	}{
+		{unit{"m", "length", 1, 0}, []string{"m", "meter", "meters", "metre", "metres"}},
+		{unit{"km", "length", 1000, 0}, []string{"km", "kilometer", "kilometers", "kilometre", "kilometres"}},
+		{unit{"cm", "length", 0.01, 0}, []string{"cm", "centimeter", "centimeters", "centimetre", "centimetres"}},
+		{unit{"mm", "length", 0.001, 0}, []string{"mm", "millimeter", "millimeters", "millimetre", "millimetres"}},
+		{unit{"mi", "length", 1609.344, 0}, []string{"mi", "mile", "miles"}},
+		{unit{"yd", "length", 0.9144, 0}, []string{"yd", "yard", "yards"}},
+		{unit{"ft", "length", 0.3048, 0}, []string{"ft", "foot", "feet"}},
+		{unit{"in", "length", 0.0254, 0}, []string{"in", "inch", "inches"}},
+		{unit{"nmi", "length", 1852, 0}, []string{"nmi", "nautical mile", "nautical miles"}},
+
+		{unit{"kg", "mass", 1, 0}, []string{"kg", "kilogram", "kilograms", "kilo", "kilos"}},
+		{unit{"g", "mass", 0.001, 0}, []string{"g", "gram", "grams", "gramme", "grammes"}},
+		{unit{"mg", "mass", 0.000001, 0}, []string{"mg", "milligram", "milligrams"}},
+		{unit{"t", "mass", 1000, 0}, []string{"t", "tonne", "tonnes"}},
+		{unit{"lb", "mass", 0.45359237, 0}, []string{"lb", "lbs", "pound", "pounds"}},
+		{unit{"oz", "mass", 0.028349523125, 0}, []string{"oz", "ounce", "ounces"}},
+		{unit{"st", "mass", 6.35029318, 0}, []string{"st", "stone", "stones"}},
+
+		{unit{"l", "volume", 1, 0}, []string{"l", "liter", "liters", "litre", "litres"}},
+		{unit{"ml", "volume", 0.001, 0}, []string{"ml", "milliliter", "milliliters", "millilitre", "millilitres"}},
+		{unit{"cl", "volume", 0.01, 0}, []string{"cl", "centiliter", "centiliters", "centilitre", "centilitres"}},
+		{unit{"gal", "volume", 3.785411784, 0}, []string{"gal", "gallon", "gallons"}},
+		{unit{"qt", "volume", 0.946352946, 0}, []string{"qt", "quart", "quarts"}},
+		{unit{"pt", "volume", 0.473176473, 0}, []string{"pt", "pint", "pints"}},
+		{unit{"cup", "volume", 0.2365882365, 0}, []string{"cup", "cups"}},
+		{unit{"fl oz", "volume", 0.0295735295625, 0}, []string{"fl oz", "floz"}},
+
+		{unit{"°C", "temperature", 1, 273.15}, []string{"°c", "c", "celsius", "degrees celsius"}},
+		{unit{"°F", "temperature", 5.0 / 9, 459.67 * 5 / 9}, []string{"°f", "f", "fahrenheit", "degrees fahrenheit"}},
+		{unit{"K", "temperature", 1, 0}, []string{"k", "kelvin", "kelvins"}},
+
+		{unit{"s", "time", 1, 0}, []string{"s", "sec", "second", "seconds"}},
+		{unit{"ms", "time", 0.001, 0}, []string{"ms", "millisecond", "milliseconds"}},
+		{unit{"min", "time", 60, 0}, []string{"min", "minute", "minutes"}},
+		{unit{"h", "time", 3600, 0}, []string{"h", "hr", "hour", "hours"}},
+		{unit{"days", "time", 86400, 0}, []string{"day", "days"}},
+		{unit{"weeks", "time", 604800, 0}, []string{"week", "weeks"}},
+
+		{unit{"m/s", "speed", 1, 0}, []string{"m/s"}},
+		{unit{"km/h", "speed", 1 / 3.6, 0}, []string{"km/h", "kmh", "kph"}},
+		{unit{"mph", "speed", 0.44704, 0}, []string{"mph"}},
+		{unit{"kn", "speed", 1852 / 3600.0, 0}, []string{"kn", "knot", "knots"}},
+
+		{unit{"m²", "area", 1, 0}, []string{"m²", "m2", "sqm", "square meter", "square meters", "square metre", "square metres"}},
+		{unit{"km²", "area", 1e6, 0}, []string{"km²", "km2", "square kilometer", "square kilometers"}},
+		{unit{"ha", "area", 1e4, 0}, []string{"ha", "hectare", "hectares"}},
+		{unit{"acres", "area", 4046.8564224, 0}, []string{"acre", "acres"}},
+		{unit{"ft²", "area", 0.09290304, 0}, []string{"ft²", "ft2", "sqft", "square foot", "square feet"}},
+
+		{unit{"B", "data", 1, 0}, []string{"b", "byte", "bytes"}},
+		{unit{"kB", "data", 1e3, 0}, []string{"kb", "kilobyte", "kilobytes"}},
+		{unit{"MB", "data", 1e6, 0}, []string{"mb", "megabyte", "megabytes"}},
+		{unit{"GB", "data", 1e9, 0}, []string{"gb", "gigabyte", "gigabytes"}},
+		{unit{"TB", "data", 1e12, 0}, []string{"tb", "terabyte", "terabytes"}},
+		{unit{"KiB", "data", 1 << 10, 0}, []string{"kib", "kibibyte", "kibibytes"}},
+		{unit{"MiB", "data", 1 << 20, 0}, []string{"mib", "mebibyte", "mebibytes"}},
+		{unit{"GiB", "data", 1 << 30, 0}, []string{"gib", "gibibyte", "gibibytes"}},
+	}
+
+	for i := range definitions {
+		for _, name := range definitions[i].names {
+			units[name] = &definitions[i].unit
+			unitNames = append(unitNames, name)
+		}
+	}
+
+	sort.Slice(unitNames, func(i, j int) bool {
+		if len(unitNames[i]) != len(unitNames[j]) {
+			return len(unitNames[i]) > len(unitNames[j])
+		}
+		return unitNames[i] < unitNames[j]
+	})
+}
+
+// conversionRegexp splits "5 miles in km" at its last separator.
+var conversionRegexp = regexp.MustCompile(`^(.*\S)\s+(?:in|to|into|as|=)\s+(\S.*)$`)
+
+// Convert converts a quantity between units, from a query like "5 miles in km".
+// It returns the quantity, its conversion, and false if the query isn't a conversion.
+func Convert(q string) (string, string, bool) {
+
+	matches := conversionRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(q)))
+	if matches == nil {
+		return "", "", false
+	}
+
+	to := units[strings.Join(strings.Fields(matches[2]), " ")]
+	if to == nil {
+		return "", "", false
+	}
+
+	// The quantity may be an expression: "2*3 km in miles", "5km in miles"
+	quantity := matches[1]
+	for _, name := range unitNames {
+
+		if !strings.HasSuffix(quantity, name) {
+			continue
+		}
+		from := units[name]
+		if from.Dimension != to.Dimension {
+			continue
+		}
+
+		expr := strings.TrimSpace(strings.TrimSuffix(quantity, name))
+		if expr == "" || len(expr) > calcMaxLength {
+			continue
+		}
+
+		tokens, err := calcTokenize(expr)
+		if err != nil {
+			continue
+		}
+		value, err := calcEvaluate(tokens)
+		if err != nil {
+			continue
+		}
+
+		converted := (value*from.Factor + from.Offset - to.Offset) / to.Factor
+
+		return formatCalcNumber(value) + " " + from.Symbol, formatCalcNumber(converted) + " " + to.Symbol, true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// userAgentQueries are the queries answered with the User-Agent of the browser.
+var userAgentQueries = map[string]bool{
+	"user agent":            true,
+	"useragent":             true,
+	"my user agent":         true,
+	"what is my user agent": true,
+	"what's my user agent":  true,
+	"whats my user agent":   true,
+}
+
+// UserAgentAnswer is the Data of useragent answers.
+type UserAgentAnswer struct {
+	UserAgent string `json:"ua"`
+}
+
+// userAgentProvider tells users the User-Agent of their browser.
+type userAgentProvider struct{}
+
+func init() {
+	RegisterAnswerProvider(userAgentProvider{}, 10*time.Millisecond)
+}
+
+func (userAgentProvider) Name() string {
+	return "useragent"
+}
+
+func (userAgentProvider) Match(req SearchRequest) bool {
+	return userAgentQueries[strings.Join(strings.Fields(strings.ToLower(strings.TrimRight(req.Query, "?"))), " ")]
+}
+
+func (userAgentProvider) Answer(ctx context.Context, req SearchRequest) (*Answer, error) {
+
+	if req.UserAgent == "" {
+		return nil, nil
+	}
+
+	return &Answer{Confidence: 1, Data: UserAgentAnswer{UserAgent: req.UserAgent}}, nil
+}
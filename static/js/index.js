@@ -141,8 +141,9 @@
   // Get the associated URL to a Search object
   // Same function is used on the server side
   // If pageDiff is false, don't include page numbers in URLs
+  // The safe search level is only included when it isn't the default one, unless explicitSafe is set
   // Parameters must be alphabetically sorted for caching
-  var getSearchHref = function(search, pageDiff) {
+  var getSearchHref = function(search, pageDiff, explicitSafe) {
 
     var components = [];
 
@@ -158,6 +159,26 @@
       components.push("q=" + encodeURIComponent(search["q"]).replace(/%20/g, "+"));
     }
 
+    if (search["safe"] && (explicitSafe || search["safe"] !== $id("ss").getAttribute("data-default"))) {
+      components.push("safe=" + encodeURIComponent(search["safe"]));
+    }
+
+    if (search["q"] && search["sn"]) {
+      components.push("sn=" + encodeURIComponent(search["sn"]));
+    }
+
+    if (search["q"] && search["x"]) {
+      components.push("x=1");
+    }
+
+    if (search["q"] && search["explain"]) {
+      components.push("explain=1");
+    }
+
+    if (search["q"] && search["profile"]) {
+      components.push("profile=" + encodeURIComponent(search["profile"]));
+    }
+
     if (!components.length) {
       return "/";
     }
@@ -180,8 +201,11 @@
       eltHits = $id("hits"),
       eltPagination = $id("pager"),
       eltDebug = $id("dbg"),
+      eltExplain = $id("xp"),
       eltLang = $id("g").childNodes[0],
       eltLogo = $id("logo"),
+      eltCompletions = $id("ac"),
+      eltSafeSearch = $id("ss"),
       eltTitle = document.getElementsByTagName('title')[0];
 
   // Page layout (are we on the homepage or search results?) is controlled by a single CSS class
@@ -192,18 +216,56 @@
   // the browser's network cache directly in all cases?
   var lastSentSearch = window.JSON.parse(eltForm.getAttribute("data-init") || "{}");
 
+  // Interface messages of the current locale, see server/locales.go
+  var locale = window.JSON.parse(eltForm.getAttribute("data-locale") || "{}");
+
+  // Formats an integer with the thousands separator of the locale
+  var formatNumber = function(n) {
+    return String(n).replace(/\B(?=(\d{3})+(?!\d))/g, locale["thousands"] || ",");
+  };
+
+  // Translates a message of the interface
+  // Same function is used on the server side: arguments are HTML-escaped and numbers are formatted
+  var t = function(key) {
+    var args = Array.prototype.slice.call(arguments, 1);
+    var message = (locale["messages"] || {})[key];
+    if (message === undefined) return key;
+    return message.replace(/%s/g, function() {
+      var arg = args.shift();
+      return (typeof arg == "number") ? formatNumber(arg) : htmlSafe(arg);
+    });
+  };
+
   // The last search object we considered acting upon
   var lastConsideredSearch = lastSentSearch;
 
   // Current in-flight XMLHttpRequest
   var currentHttpRequest = null;
 
+  // Formats a score like "%.4g" on the server side
+  var formatScore = function(score) {
+    return String(parseFloat(score.toPrecision(4)));
+  };
+
+  // Formats the factors of an explained score, like the server side
+  var formatScoreFactors = function(factors) {
+    var html = "";
+    for (var i = 0; i < (factors || []).length; i++) {
+      html += " &times; " + factors[i]["n"] + " " + formatScore(factors[i]["v"]);
+    }
+    return html;
+  };
+
   // Returns the Search object currently input by the user (not necessarily displayed yet)
   var getCurrentSearch = function() {
     return {
       "q": eltSearchInput.value.trim(),
       "p": parseInt(eltPagination.getAttribute("data-page"), 10) || 1,
-      "g": eltLang.value
+      "g": eltLang.value,
+      "sn": lastSentSearch["sn"],
+      "safe": lastSentSearch["safe"],
+      "explain": lastSentSearch["explain"],
+      "profile": lastSentSearch["profile"]
     };
   };
 
@@ -273,47 +335,133 @@
 
     html += "<div class='info'>";
     if (result["c"]) {
-      html += "<div id='c'>About " + result["c"] + " results</div>";
+      html += "<div id='c'>" + t("results", result["c"]) + "</div>";
     }
 
     if (result["e"]) {
-      html += "<div id='e'>\"" + result["e"] + "\" (and subsequent words) was ignored because we limit queries to 10 words.</div>";
+      html += "<div id='e'>" + t("extra", result["e"], 10) + "</div>";
+    }
+
+    // Operators like site:example.com can be removed from the query in one click
+    var operators = (result["s"] || {})["o"] || [];
+    for (var j = 0; j < operators.length; j++) {
+      var op = operators[j];
+      html += "<a class='op' href='" + getSearchHref({"q": op["r"], "g": search["g"]}, false) + "' title='" + t("remove_filter") + "'>" +
+                (op["n"] ? "-" : "") + op["f"] + ":" + htmlSafe(op["v"]) + " &times;" +
+              "</a>";
     }
     html += "</div>";
 
+    // Instant answers are rendered on the server side
+    var answer = result["a"];
+    if (answer) {
+      html += "<div id='an' class='" + answer["t"] + "'>" + answer["h"] + "</div>";
+    }
+
+    var suggestion = result["sg"];
+    if (suggestion && suggestion["c"]) {
+      html += "<div id='sg'>" + t("corrected", suggestion["h"], suggestion["q"], suggestion["o"], search["q"]) + "</div>";
+    } else if (suggestion) {
+      html += "<div id='sg'>" + t("did_you_mean", suggestion["h"], suggestion["q"]) + "</div>";
+    }
+
+    if (result["gt"]) {
+      html += "<div id='gt'>" + t("go_to", result["gt"], result["gt"]) + "</div>";
+    }
+
+    // Pages named by the query are displayed before the other hits
+    var pinned = result["pn"];
+    if (pinned) {
+      html += "<div class='r pn'>" +
+                "<h3><a href='"+pinned["u"]+"' tabindex='"+(tabIndexCount+=1)+"'>"+pinned["t"]+"</a></h3>" +
+                "<div class='u'><a href='"+pinned["u"]+"' tabIndex='-1'>" + simplifyURL(pinned["u"]) + "</a></div>" +
+                "<div class='s'>"+pinned["s"]+"</div>" +
+              "</div>";
+    }
+
     for (var i = 0; i < (result["h"] || []).length; i++) {
       var hit = result["h"][i];
-      html += "<div class='r'>" +
+      html += "<div class='r'" + (hit["xn"] ? " data-xn='" + hit["xn"] + "'" : "") + ">" +
                 "<h3><a href='"+hit["u"]+"' tabindex='"+(tabIndexCount+=1)+"'>"+hit["t"]+"</a></h3>" +
                 "<div class='u'><a href='"+hit["u"]+"' tabIndex='-1'>" + simplifyURL(hit["u"]) + "</a></div>" +
                 "<div class='s'>"+hit["s"]+"</div>" +
+                (hit["mr"] ? "<div class='mr'><a href='" + hit["mr"]["h"] + "'>" + t("more_from", hit["mr"]["d"]) + "</a></div>" : "") +
+                (hit["ex"] ? "<details class='sc'><summary>" + formatScore(hit["ex"]["sc"]) + "</summary>" +
+                  t("explain_text", formatScore(hit["ex"]["tx"])) + formatScoreFactors(hit["ex"]["f"]) + "</details>" : "") +
               "</div>";
     }
 
-    if (!html && search["q"]) {
-      html = "<div class='z'>We didn't find any results for this search, sorry!</div>";
+    if (result["pl"]) {
+      html += "<div class='z'>" + t("no_more_pages") + "</div>";
+    } else if (!(result["h"] || []).length && !pinned && search["q"]) {
+      html += "<div class='z'>" + t("no_results") + "</div>";
+    }
+
+    var facets = result["f"] || [];
+    if (facets.length) {
+      html += "<div id='fc'>" + t("filter_by_site") + " ";
+      for (var k = 0; k < facets.length; k++) {
+        html += "<a href='" + facets[k]["h"] + "'>" + htmlSafe(facets[k]["d"]) + "</a> <span>(" + formatNumber(facets[k]["c"]) + ")</span> ";
+      }
+      html += "</div>";
+    }
+
+    var related = result["rl"] || [];
+    if (related.length) {
+      html += "<div id='rl'>" + t("related") + " ";
+      for (var r = 0; r < related.length; r++) {
+        html += "<a href='" + related[r]["h"] + "'>" + htmlSafe(related[r]["q"]) + "</a> ";
+      }
+      html += "</div>";
     }
     eltHits.innerHTML = html;
 
+    // Interleaved hits of ranking experiments report their clicks
+    if (result["xp"]) {
+      eltHits.setAttribute("data-xp", result["xp"]["e"]);
+      eltHits.setAttribute("data-xi", result["xp"]["i"]);
+    } else {
+      eltHits.removeAttribute("data-xp");
+      eltHits.removeAttribute("data-xi");
+    }
+
     var paginationHTML = "";
     if (search.p && search.p > 1) {
-      paginationHTML = '<a href="' + getSearchHref(search, -1) + '">&laquo; Previous</a>';
+      paginationHTML = '<a href="' + getSearchHref(search, -1) + '">' + t("previous") + '</a>';
     }
     // Do we have more results?
     if (result.m) {
-      paginationHTML += '<a href="' + getSearchHref(search, 1) + '">Next &raquo;</a>';
+      paginationHTML += '<a href="' + getSearchHref(search, 1) + '">' + t("next") + '</a>';
     }
     eltPagination.innerHTML = paginationHTML;
 
     eltPagination.setAttribute("data-page", search.p);
 
+    // Safe search links keep the current query
+    var safeLinks = eltSafeSearch.getElementsByTagName("a");
+    for (var l = 0; l < safeLinks.length; l++) {
+      var safeSearch = {"q": search["q"], "g": search["g"], "sn": search["sn"], "safe": safeLinks[l].getAttribute("data-safe")};
+      safeLinks[l].href = getSearchHref(safeSearch, false, true);
+    }
+
     if (!result["t"]) {
       eltDebug.innerHTML = "";
     } else {
-      var t = result["t"];
-      eltDebug.innerHTML = "Text: <span>"+t["tq"]+" / "+t["tr"] + "us</span><br/>" +
-                           "Docs: <span>"+t["dq"]+" / "+t["dr"] + "us</span><br/>" +
-                           "Total: <span>"+t["o"] + "us</span><br/>";
+      var timing = result["t"];
+      eltDebug.innerHTML = t("timing_text", timing["tq"], timing["tr"]) + "<br/>" +
+                           t("timing_docs", timing["dq"], timing["dr"]) + "<br/>" +
+                           t("timing_total", timing["o"]) + "<br/>";
+    }
+
+    var debug = result["dbg"];
+    if (!debug) {
+      eltExplain.innerHTML = "";
+    } else {
+      eltExplain.innerHTML = "<details><summary>" + t("explain") + "</summary>" +
+                             "<pre>" + htmlSafe(debug["tb"]) + "</pre>" +
+                             (debug["db"] ? "<pre>" + htmlSafe(debug["db"]) + "</pre>" : "") +
+                             (debug["pf"] ? "<pre>" + htmlSafe(window.JSON.stringify(debug["pf"])) + "</pre>" : "") +
+                             "</details>";
     }
 
   };
@@ -383,6 +531,37 @@
     newSearch(false, false);
   }, 150);
 
+  // Current in-flight autocomplete XMLHttpRequest
+  var currentCompletionRequest = null;
+
+  // Fills the list of completions for the query being typed
+  var updateCompletions = function() {
+
+    var search = getCurrentSearch();
+    search["q"] = eltSearchInput.value.replace(/^\s+/, "");
+
+    if (currentCompletionRequest) currentCompletionRequest.abort();
+
+    if (!search["q"]) {
+      eltCompletions.innerHTML = "";
+      return;
+    }
+
+    currentCompletionRequest = requestJSON("GET", "/api/suggest" + getSearchHref(search, false).substring(1), {}, function(err, result) {
+
+      currentCompletionRequest = null;
+      if (err) return;
+
+      var html = "";
+      for (var i = 0; i < result["s"].length; i++) {
+        html += "<option value=\"" + htmlSafe(result["s"][i]) + "\"></option>";
+      }
+      eltCompletions.innerHTML = html;
+    });
+  };
+
+  var updateCompletionsDebounced = debounce(updateCompletions, 50);
+
 
   // Before binding events, we autodetect the language if it was empty on load!
   if (!lastSentSearch["g"]) {
@@ -406,6 +585,7 @@
     }
 
     newSearchDebounced();
+    updateCompletionsDebounced();
 
     // No need to propagate further and trigger onchange() events etc.
     event.stopPropagation();
@@ -413,6 +593,29 @@
   };
 
   // Submitting the form causes a search right away
+  // Sends a click beacon for interleaved hits, see server/experiments.go
+  eltHits.onmousedown = function(event) {
+    var experiment = eltHits.getAttribute("data-xp");
+    if (!experiment) return;
+
+    var link = false;
+    for (var elt = event.target; elt && elt !== eltHits; elt = elt.parentNode) {
+      if (elt.tagName == "A") {
+        link = true;
+      } else if (link && elt.getAttribute("data-xn")) {
+        var url = "/api/click?e=" + encodeURIComponent(experiment) +
+                  "&i=" + encodeURIComponent(eltHits.getAttribute("data-xi")) +
+                  "&n=" + elt.getAttribute("data-xn");
+        if (navigator.sendBeacon) {
+          navigator.sendBeacon(url);
+        } else {
+          requestJSON("POST", url, {}, function() {});
+        }
+        return;
+      }
+    }
+  };
+
   eltForm.onsubmit = function(evt) {
     newSearch(true, false);
     return false;